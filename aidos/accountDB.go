@@ -21,10 +21,14 @@
   package aidos
 
   import (
+  	"bufio"
   	"encoding/json"
-  	"errors"
+  	"fmt"
+  	"io"
   	"log"
   	"math"
+  	"strings"
+  	"sync"
 
   	"github.com/AidosKuneen/gadk"
   	"github.com/boltdb-go/bolt"
@@ -32,20 +36,46 @@
 
   var accountDB = []byte("accounts")
 
-  var lastAccount *Account
-
   //Balance represents balance, with change value.
+  //Value is the confirmed balance in the ledger, see ledger.go.
   type Balance struct {
   	gadk.Balance
-  	Change int64
+  	Change  int64
+  	Spent   int64 `json:",omitempty"` //values deducted from Value whose spending txs are not confirmed
+  	Pending int64 `json:",omitempty"` //unconfirmed incoming values from others
   }
 
   //Account represents account for bitcoind api.
+  //The seed is kept encrypted, and is decrypted only while signing or deriving addresses by withSeed.
+  //A watch-only account has only addresses and no seed, so it cannot send tokens.
   type Account struct {
-  	Name     string
-  	Seed     gadk.Trytes `json:"-"`
-  	EncSeed  []byte
-  	Balances []Balance
+  	Name      string
+  	EncSeed   []byte
+  	Balances  []Balance
+  	WatchOnly bool `json:",omitempty"`
+  }
+
+  func newAccount(name string, seed gadk.Trytes) *Account {
+  	return &Account{
+  		Name:    name,
+  		EncSeed: block.encrypt([]byte(seed)),
+  	}
+  }
+
+  //withSeed decrypts the seed, calls f with it, and wipes the decrypted seed.
+  //f must not keep the seed after it returns.
+  //Signing in gadk needs the seed as a string, so f gets a copy of it which cannot be wiped
+  //and remains in memory until it is garbage-collected.
+  func (a *Account) withSeed(f func(seed gadk.Trytes) error) error {
+  	if a.WatchOnly {
+  		return errWatchOnly
+  	}
+  	seed, err := block.decrypt(a.EncSeed)
+  	if err != nil {
+  		return err
+  	}
+  	defer wipe(seed)
+  	return f(gadk.Trytes(seed))
   }
 
   func toKey(name string) []byte {
@@ -67,6 +97,23 @@
   	return t
   }
 
+  //withPendingChange calls f while pending changes of a can be spent as if they were confirmed.
+  //A spent change is left as a negative value, which is cancelled out when Walletnotify adds the confirmed change.
+  func (a *Account) withPendingChange(f func() error) error {
+  	changes := make(map[gadk.Address]int64)
+  	for i := range a.Balances {
+  		if c := a.Balances[i].Change; c > 0 {
+  			changes[a.Balances[i].Address] = c
+  			a.Balances[i].Value += c
+  		}
+  	}
+  	err := f()
+  	for i := range a.Balances {
+  		a.Balances[i].Value -= changes[a.Balances[i].Address]
+  	}
+  	return err
+  }
+
   func (a *Account) search(adr gadk.Address) int {
   	index := -1
   	for i, bal := range a.Balances {
@@ -77,45 +124,84 @@
   	return index
   }
 
-  func findAddress(tx *bolt.Tx, adr gadk.Address) (*Account, int, error) {
-  	if lastAccount != nil {
-  		i := lastAccount.search(adr)
-  		if i >= 0 {
-  			return lastAccount, i, nil
-  		}
+  var addressAccountDB = []byte("address_accounts") // address -> account name
+
+  //adrIndex is an in-memory copy of addressAccountDB.
+  var (
+  	adrIndex = make(map[gadk.Address]string)
+  	adrMutex sync.RWMutex
+  )
+
+  //buildAddressIndex builds the address index from accounts.
+  func buildAddressIndex(tx *bolt.Tx) error {
+  	b, err := tx.CreateBucketIfNotExists(addressAccountDB)
+  	if err != nil {
+  		return err
   	}
-  	b := tx.Bucket(accountDB)
-  	if b == nil {
-  		return nil, -1, nil
+  	ba := tx.Bucket(accountDB)
+  	if ba == nil {
+  		return nil
   	}
-  	c := b.Cursor()
-  	var result *Account
-  	index := -1
-  	for k, v := c.First(); k != nil; k, v = c.Next() {
+  	return ba.ForEach(func(k, v []byte) error {
   		var ac Account
   		if err := json.Unmarshal(v, &ac); err != nil {
-  			return nil, -1, err
+  			return err
   		}
-  		index = -1
-  		for i, a := range ac.Balances {
-  			if adr == a.Address {
-  				index = i
+  		for _, bal := range ac.Balances {
+  			if err := b.Put([]byte(bal.Address), k); err != nil {
+  				return err
   			}
   		}
-  		if index == -1 {
-  			continue
+  		return nil
+  	})
+  }
+
+  //loadAddressIndex loads the address index into memory.
+  func loadAddressIndex(tx *bolt.Tx) error {
+  	index := make(map[gadk.Address]string)
+  	if b := tx.Bucket(addressAccountDB); b != nil {
+  		err := b.ForEach(func(k, v []byte) error {
+  			index[gadk.Address(k)] = string(v)
+  			return nil
+  		})
+  		if err != nil {
+  			return err
   		}
-  		seed := block.decrypt(ac.EncSeed)
-  		ac.Seed = gadk.Trytes(seed)
-  		result = &ac
-  		lastAccount = result
-  		break
   	}
-  	return result, index, nil
+  	adrMutex.Lock()
+  	adrIndex = index
+  	adrMutex.Unlock()
+  	return nil
+  }
+
+  //findAddress returns the account which has the address adr and the index of adr in Balances.
+  func findAddress(tx *bolt.Tx, adr gadk.Address) (*Account, int, error) {
+  	adrMutex.RLock()
+  	name, ok := adrIndex[adr]
+  	adrMutex.RUnlock()
+  	if !ok {
+  		return nil, -1, nil
+  	}
+  	b := tx.Bucket(accountDB)
+  	if b == nil {
+  		return nil, -1, nil
+  	}
+  	v := b.Get([]byte(name))
+  	if v == nil {
+  		return nil, -1, nil
+  	}
+  	var ac Account
+  	if err := json.Unmarshal(v, &ac); err != nil {
+  		return nil, -1, err
+  	}
+  	index := ac.search(adr)
+  	if index < 0 {
+  		return nil, -1, nil
+  	}
+  	return &ac, index, nil
   }
 
   var globalAccountNo int = -1
-  var globalAccount Account
 
   func listAccount(tx *bolt.Tx) ([]Account, error) {
   	var asc []Account
@@ -130,8 +216,6 @@
   		if err := json.Unmarshal(v, &ac); err != nil {
   			return nil, err
   		}
-  		seed := block.decrypt(ac.EncSeed)
-  		ac.Seed = gadk.Trytes(seed)
   		asc = append(asc, ac)
   	}
   	if (globalAccountNo == -1){ //no account unspecified
@@ -140,12 +224,15 @@
   	return asc[globalAccountNo:globalAccountNo+1], nil // return specific account slice
   }
 
+  //getAccount returns the account named name, or the one selected by account_no if set.
+  //The selected account is read from the DB every time, so that it has the latest ledger.
   func getAccount(tx *bolt.Tx, name string) (*Account, error) {
-    if globalAccountNo > -1 {
-      return &globalAccount, nil
-    }
-  	if lastAccount != nil && lastAccount.Name == name {
-  		return lastAccount, nil
+  	if globalAccountNo > -1 {
+  		acs, err := listAccount(tx)
+  		if err != nil || len(acs) == 0 {
+  			return nil, err
+  		}
+  		return &acs[0], nil
   	}
   	var ac Account
   	b := tx.Bucket(accountDB)
@@ -159,27 +246,41 @@
   	if err := json.Unmarshal(v, &ac); err != nil {
   		return nil, err
   	}
-  	seed := block.decrypt(ac.EncSeed)
-  	ac.Seed = gadk.Trytes(seed)
   	return &ac, nil
   }
 
   func putAccount(tx *bolt.Tx, acc *Account) error {
-  	if lastAccount != nil && lastAccount.Name == acc.Name {
-  		lastAccount = acc
-  	}
   	b, err := tx.CreateBucketIfNotExists(accountDB)
   	if err != nil {
   		return err
   	}
-  	if acc.EncSeed == nil {
-  		acc.EncSeed = block.encrypt([]byte(acc.Seed))
-  	}
   	bin, err := json.Marshal(acc)
   	if err != nil {
   		return err
   	}
-  	return b.Put(toKey(acc.Name), bin)
+  	key := toKey(acc.Name)
+  	if err = b.Put(key, bin); err != nil {
+  		return err
+  	}
+  	bi, err := tx.CreateBucketIfNotExists(addressAccountDB)
+  	if err != nil {
+  		return err
+  	}
+  	adrs := make([]gadk.Address, 0, len(acc.Balances))
+  	for _, bal := range acc.Balances {
+  		if err = bi.Put([]byte(bal.Address), key); err != nil {
+  			return err
+  		}
+  		adrs = append(adrs, bal.Address)
+  	}
+  	tx.OnCommit(func() {
+  		adrMutex.Lock()
+  		for _, adr := range adrs {
+  			adrIndex[adr] = string(key)
+  		}
+  		adrMutex.Unlock()
+  	})
+  	return nil
   }
 
   func RestoreAddressesFromSeed(conf *Conf, seed gadk.Trytes) error {
@@ -191,17 +292,14 @@
   			return err
   		}
   		if ac != nil {
-  			return errors.New("an account already exists")
-  		}
-  		ac = &Account{
-  			Name: acc,
-  			Seed: seed,
+  			return newErr(RPCWalletError, "an account already exists")
   		}
+  		ac = newAccount(acc, seed)
 
   		var count int
   		for i := 0; i < math.MaxInt32; i++ {
   			// TODO Move "2" magic number to a constant
-  			adr, err := gadk.NewAddress(ac.Seed, i, 2)
+  			adr, err := gadk.NewAddress(seed, i, 2)
   			if err != nil {
   				break
   			}
@@ -220,7 +318,7 @@
   			}
   		}
 
-  		addresses, err := gadk.NewAddresses(ac.Seed, 0, count, 2)
+  		addresses, err := gadk.NewAddresses(seed, 0, count, 2)
   		if err != nil {
   			return err
   		}
@@ -235,6 +333,81 @@
   	})
   }
 
+  //importAddress adds adr to the watch-only account name. It does nothing if adr is already in the wallet.
+  func importAddress(tx *bolt.Tx, name string, adr gadk.Address) error {
+  	ac, _, err := findAddress(tx, adr)
+  	if err != nil || ac != nil {
+  		return err
+  	}
+  	if ac, err = getAccount(tx, name); err != nil {
+  		return err
+  	}
+  	if ac == nil {
+  		ac = &Account{
+  			Name:      name,
+  			WatchOnly: true,
+  		}
+  	}
+  	if !ac.WatchOnly {
+  		return newErr(RPCWalletError, "cannot import an address into an account with a seed")
+  	}
+  	ac.Balances = append(ac.Balances, Balance{
+  		Balance: gadk.Balance{
+  			Address: adr,
+  		},
+  	})
+  	return putAccount(tx, ac)
+  }
+
+  //ImportAddresses imports addresses (one per line) from r to the watch-only account
+  //and returns the number of addresses read.
+  func ImportAddresses(r io.Reader) (int, error) {
+  	var adrs []gadk.Address
+  	s := bufio.NewScanner(r)
+  	for s.Scan() {
+  		line := strings.TrimSpace(s.Text())
+  		if line == "" {
+  			continue
+  		}
+  		adr, err := gadk.ToAddress(line)
+  		if err != nil {
+  			return 0, fmt.Errorf("invalid address %s: %v", line, err)
+  		}
+  		adrs = append(adrs, adr)
+  	}
+  	if err := s.Err(); err != nil {
+  		return 0, err
+  	}
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		for _, adr := range adrs {
+  			if err := importAddress(tx, "", adr); err != nil {
+  				return err
+  			}
+  		}
+  		return nil
+  	})
+  	return len(adrs), err
+  }
+
+  //ExportAddresses writes all addresses in the wallet to w, one per line,
+  //which can be imported to a watch-only wallet.
+  func ExportAddresses(w io.Writer) error {
+  	return db.View(func(tx *bolt.Tx) error {
+  		acs, err := listAccount(tx)
+  		if err != nil {
+  			return err
+  		}
+  		for _, ac := range acs {
+  			for _, b := range ac.Balances {
+  				if _, err := fmt.Fprintln(w, b.Address.WithChecksum()); err != nil {
+  					return err
+  				}
+  			}
+  		}
+  		return nil
+  	})
+  }
+
   func ListAndSelectAccount(conf  *Conf){
   	  globalAccountNo = conf.accountNo
   	  log.Println("Checking for multiple accounts: ")
@@ -249,7 +422,6 @@
   				bal := ac.totalValueWithChange()
   				log.Printf("Account found: Account number %v : %s, Balance: %v \n", idx, ac.Name, bal)
   				cnt++
-          globalAccount = ac
   			}
   			if cnt > 1 && conf.accountNo == -1 {
   				log.Fatal("\n**************************\nERROR: More than one account found! Please specify the account to use in aidosd.conf: e.g. account_no=0  \n\n**********************\n  ")
@@ -273,10 +445,7 @@
   		if err2 != nil {
   			return err2
   		}
-  		hs, err2 := getHashes(tx)
-  		if err2 != nil {
-  			return err2
-  		}
+  		var hs []*txstate
   		for _, ac := range acc {
   			log.Println("processing account", ac.Name)
   			var adrs []gadk.Address
@@ -293,9 +462,13 @@
   			}
   			log.Println("updating hashes")
   			for _, h1 := range r.Hashes {
-  				exist := false
-  				for _, h2 := range hs {
-  					if h1 == h2.Hash {
+  				h2, err2 := getHash(tx, h1)
+  				if err2 != nil {
+  					return err2
+  				}
+  				exist := h2 != nil
+  				for _, h3 := range hs {
+  					if h1 == h3.Hash {
   						exist = true
   						break
   					}
@@ -344,8 +517,7 @@
   //ResetDB reset hashes and balances (basically remove all the hashes and set balances to 0)
   func ResetDB(conf *Conf) {
   	err := db.Update(func(tx *bolt.Tx) error {
-  		var hs []*txstate
-  		if err := putHashes(tx, hs); err != nil {
+  		if err := resetHashes(tx); err != nil {
   			return err
   		}
   		acc, err2 := listAccount(tx)
@@ -355,6 +527,8 @@
   		for _, ac := range acc {
   			for i := range ac.Balances {
   				ac.Balances[i].Balance.Value = 0
+  				ac.Balances[i].Spent = 0
+  				ac.Balances[i].Pending = 0
   			}
   			if err := putAccount(tx, &ac); err != nil {
   				return err
@@ -0,0 +1,260 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+
+  package aidos
+
+  import (
+  	"bytes"
+  	"encoding/json"
+  	"strings"
+  	"testing"
+
+  	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
+  )
+
+  func TestAddressIndex(t *testing.T) {
+  	prepareTest(t)
+  	adr1 := gadk.Address("A" + gadk.EmptyAddress[1:])
+  	adr2 := gadk.Address("B" + gadk.EmptyAddress[1:])
+  	adr3 := gadk.Address("C" + gadk.EmptyAddress[1:])
+  	ac := &Account{
+  		Name:    "ac1",
+  		EncSeed: block.encrypt([]byte("SEED")),
+  		Balances: []Balance{
+  			{Balance: gadk.Balance{Address: adr1}},
+  			{Balance: gadk.Balance{Address: adr2}},
+  		},
+  	}
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		return putAccount(tx, ac)
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	check := func() {
+  		err := db.View(func(tx *bolt.Tx) error {
+  			ac2, i, err := findAddress(tx, adr2)
+  			if err != nil {
+  				return err
+  			}
+  			if ac2 == nil || ac2.Name != "ac1" || i != 1 {
+  				t.Error("invalid account", ac2, i)
+  			}
+  			ac3, i, err := findAddress(tx, adr3)
+  			if err != nil {
+  				return err
+  			}
+  			if ac3 != nil || i != -1 {
+  				t.Error("should not be found", ac3, i)
+  			}
+  			return nil
+  		})
+  		if err != nil {
+  			t.Error(err)
+  		}
+  	}
+  	check()
+
+  	//load from DB
+  	adrIndex = make(map[gadk.Address]string)
+  	if err := db.Update(loadAddressIndex); err != nil {
+  		t.Fatal(err)
+  	}
+  	check()
+
+  	//build from accounts
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		if err := tx.DeleteBucket(addressAccountDB); err != nil {
+  			return err
+  		}
+  		if err := buildAddressIndex(tx); err != nil {
+  			return err
+  		}
+  		return loadAddressIndex(tx)
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	check()
+
+  	//rollbacked changes must not be in the index
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		ac.Balances = append(ac.Balances, Balance{Balance: gadk.Balance{Address: adr3}})
+  		if err := putAccount(tx, ac); err != nil {
+  			return err
+  		}
+  		return errTxNotFound
+  	})
+  	if err != errTxNotFound {
+  		t.Error("should be rollbacked", err)
+  	}
+  	check()
+  }
+
+  func TestWithSeed(t *testing.T) {
+  	prepareTest(t)
+  	seed := gadk.Trytes(strings.Repeat("SEED", 20) + "S")
+  	ac := newAccount("ac1", seed)
+  	if bytes.Contains(ac.EncSeed, []byte(seed)) {
+  		t.Error("seed must be encrypted")
+  	}
+  	if err := ac.withSeed(func(s gadk.Trytes) error {
+  		if s != seed {
+  			t.Error("invalid seed")
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	bin, err := json.Marshal(ac)
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	if bytes.Contains(bin, []byte(seed)) {
+  		t.Error("seed must not be marshaled")
+  	}
+  }
+
+  func TestWatchOnly(t *testing.T) {
+  	conf := prepareTest(t)
+  	adr1 := gadk.Address("A" + gadk.EmptyAddress[1:])
+  	adr2 := gadk.Address("B" + gadk.EmptyAddress[1:])
+  	adr3 := gadk.Address("C" + gadk.EmptyAddress[1:])
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		ac := newAccount("signer", gadk.Trytes(strings.Repeat("SEED", 20)+"S"))
+  		ac.Balances = []Balance{{Balance: gadk.Balance{Address: adr3}}}
+  		return putAccount(tx, ac)
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	call := func(body string) *Response {
+  		w := post(t, conf, body)
+  		var res Response
+  		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+  			t.Fatal(err)
+  		}
+  		return &res
+  	}
+  	for _, adr := range []gadk.Address{adr1, adr1} {
+  		res := call(`{"jsonrpc":"1.0","id":1,"method":"importaddress","params":["` +
+  			string(adr.WithChecksum()) + `","watch",false]}`)
+  		if res.Error != nil {
+  			t.Fatal(res.Error)
+  		}
+  	}
+  	res := call(`{"jsonrpc":"1.0","id":1,"method":"importaddress","params":["` +
+  		string(adr2.WithChecksum()) + `","signer"]}`)
+  	if res.Error == nil {
+  		t.Error("importing to an account with a seed should be error")
+  	}
+
+  	res = call(`{"jsonrpc":"1.0","id":1,"method":"validateaddress","params":["` +
+  		string(adr1.WithChecksum()) + `"]}`)
+  	if res.Error != nil {
+  		t.Fatal(res.Error)
+  	}
+  	info := res.Result.(map[string]interface{})
+  	if info["ismine"] != false || info["iswatchonly"] != true || info["account"] != "watch" {
+  		t.Error("invalid validateaddress result", info)
+  	}
+
+  	var ac *Account
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		var err error
+  		ac, err = getAccount(tx, "watch")
+  		return err
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	if ac == nil || !ac.WatchOnly || ac.EncSeed != nil || len(ac.Balances) != 1 {
+  		t.Fatal("invalid watch-only account", ac)
+  	}
+  	if err := ac.withSeed(func(gadk.Trytes) error { return nil }); err != errWatchOnly {
+  		t.Error("watch-only account must not have a seed", err)
+  	}
+  	if _, err := send("watch", conf, []gadk.Transfer{{Address: adr3, Value: 1}}, "", 1); err != errWatchOnly {
+  		t.Error("sending from a watch-only account should be refused", err)
+  	}
+
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		for name, v := range map[string]int64{"watch": 10, "signer": 5} {
+  			ac, err := getAccount(tx, name)
+  			if err != nil {
+  				return err
+  			}
+  			ac.Balances[0].Value = v
+  			if err := putAccount(tx, ac); err != nil {
+  				return err
+  			}
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	for param, total := range map[string]float64{"": 0.00000005, `,true`: 0.00000015} {
+  		res = call(`{"jsonrpc":"1.0","id":1,"method":"getbalance","params":["*",1` + param + `]}`)
+  		if res.Error != nil {
+  			t.Fatal(res.Error)
+  		}
+  		if res.Result != total {
+  			t.Error("invalid getbalance with include_watchonly", param, res.Result)
+  		}
+  		res = call(`{"jsonrpc":"1.0","id":1,"method":"listaccounts","params":[1` + param + `]}`)
+  		if res.Error != nil {
+  			t.Fatal(res.Error)
+  		}
+  		_, ok := res.Result.(map[string]interface{})["watch"]
+  		if ok != (param != "") {
+  			t.Error("invalid listaccounts with include_watchonly", param, res.Result)
+  		}
+  	}
+
+  	var buf bytes.Buffer
+  	if err := ExportAddresses(&buf); err != nil {
+  		t.Fatal(err)
+  	}
+  	exported := buf.String()
+  	prepareTest(t)
+  	n, err := ImportAddresses(strings.NewReader(exported))
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	if n != 2 {
+  		t.Error("invalid number of imported addresses", n)
+  	}
+  	if err = db.View(func(tx *bolt.Tx) error {
+  		for _, adr := range []gadk.Address{adr1, adr3} {
+  			ac, _, err := findAddress(tx, adr)
+  			if err != nil {
+  				return err
+  			}
+  			if ac == nil || !ac.WatchOnly {
+  				t.Error("address should be imported as watch-only", adr)
+  			}
+  		}
+  		//include_watchonly of listsinceblock is true by default.
+  		if wo, err := isWatchOnlyWallet(tx); err != nil || !wo {
+  			t.Error("should be a watch-only wallet", err)
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  }
@@ -21,8 +21,8 @@
   package aidos
 
   import (
+  	"bytes"
   	"encoding/json"
-  	"errors"
   	"fmt"
   	"io"
   	"io/ioutil"
@@ -67,7 +67,18 @@
   	Testnet     bool
   	PassPhrase  bool
   	Tag         string
+  	Version     string
+  	//ReattachAfter is the duration after which unconfirmed bundles sent from the wallet are reattached.
+  	ReattachAfter time.Duration
+  	//CoinSelection is the default policy to choose inputs.
+  	CoinSelection string
+  	//MaxBundleSize is the max number of txs in a bundle sent by sendmany. 0 means no limit.
+  	MaxBundleSize int
+  	//ReconcileInterval is the interval of reconciling the ledger with the node. 0 means never.
+  	ReconcileInterval time.Duration
   	api         apis
+  	pow         *powStats
+  	outboxSlots chan struct{}
   	accountNo   int
     V2          bool
   }
@@ -77,10 +88,15 @@
   	conf := Conf{
   		RPCPort:    "8332",
   		PassPhrase: true,
+  		ReattachAfter: 30 * time.Minute,
+  		ReconcileInterval: 10 * time.Minute,
   		accountNo: -1,
       V2: false,
   	}
 
+  	var powKind, powURL string
+  	var powWorkers int
+  	powParallel := 1
   	f, err := os.Open(cfile)
   	if err != nil {
   		panic(err)
@@ -118,6 +134,42 @@
   					panic("accountno must be integer " + states[1])
   				}
   				globalAccountNo = conf.accountNo
+  		case "reattach_after":
+  			min, err := strconv.Atoi(states[1])
+  			if err != nil || min < 0 {
+  				panic("reattach_after must be non-negative integer " + states[1])
+  			}
+  			conf.ReattachAfter = time.Duration(min) * time.Minute
+  		case "reconcile_interval":
+  			min, err := strconv.Atoi(states[1])
+  			if err != nil || min < 0 {
+  				panic("reconcile_interval must be non-negative integer " + states[1])
+  			}
+  			conf.ReconcileInterval = time.Duration(min) * time.Minute
+  		case "max_bundle_size":
+  			conf.MaxBundleSize, err = strconv.Atoi(states[1])
+  			if err != nil || (conf.MaxBundleSize != 0 && conf.MaxBundleSize < 4) {
+  				panic("max_bundle_size must be 0 or integer more than 3 " + states[1])
+  			}
+  		case "coin_selection":
+  			if !isCoinSelection(states[1]) {
+  				panic("unknown coin_selection " + states[1])
+  			}
+  			conf.CoinSelection = states[1]
+  		case "pow":
+  			powKind = states[1]
+  		case "pow_workers":
+  			powWorkers, err = strconv.Atoi(states[1])
+  			if err != nil || powWorkers <= 0 {
+  				panic("pow_workers must be positive integer " + states[1])
+  			}
+  		case "pow_parallel":
+  			powParallel, err = strconv.Atoi(states[1])
+  			if err != nil || powParallel <= 0 {
+  				panic("pow_parallel must be positive integer " + states[1])
+  			}
+  		case "pow_url":
+  			powURL = states[1]
   		case "walletnotify":
   			conf.Notify = states[1]
   		case "aidos_node":
@@ -155,7 +207,7 @@
   				//do nothing, it's default
   			case "false":
   				conf.PassPhrase = false
-  				privileged = true
+  				unlocker.always = true
   			default:
   				panic("passphrase must be true or false")
   			}
@@ -179,6 +231,12 @@
   	}
   	conf.Tag += "9AIDOSD"
   	conf.api = gadk.NewAPI(conf.Node, nil)
+  	pow, err := newPowProvider(powKind, powWorkers, powURL)
+  	if err != nil {
+  		panic(err)
+  	}
+  	conf.pow = &powStats{PowProvider: pow}
+  	conf.outboxSlots = make(chan struct{}, powParallel)
   	return &conf
   }
 
@@ -233,6 +291,47 @@
   	ID      interface{} `json:"id"`
   	Method  string      `json:"method"`
   	Params  interface{} `json:"params"`
+  	hasID   bool
+  }
+
+  //UnmarshalJSON parses a request and remembers whether it has an id.
+  //Numbers in params are parsed as json.Number so that amounts are not rounded by float64.
+  func (r *Request) UnmarshalJSON(b []byte) error {
+  	type request Request
+  	var fields map[string]json.RawMessage
+  	if err := json.Unmarshal(b, &fields); err != nil {
+  		return err
+  	}
+  	d := json.NewDecoder(bytes.NewReader(b))
+  	d.UseNumber()
+  	if err := d.Decode((*request)(r)); err != nil {
+  		return err
+  	}
+  	_, r.hasID = fields["id"]
+  	return nil
+  }
+
+  //isNotification returns true if r must not be responded, i.e.
+  //r doesn't have an id in JSON-RPC 2.0. Requests in 1.0 are always responded as bitcoind does.
+  func (r *Request) isNotification() bool {
+  	return r.JSONRPC == "2.0" && !r.hasID
+  }
+
+  func (r *Request) validate() *Err {
+  	switch r.JSONRPC {
+  	case "", "1.0", "2.0":
+  	default:
+  		return newErr(RPCInvalidRequest, "jsonrpc must be 1.0 or 2.0")
+  	}
+  	if r.Method == "" {
+  		return newErr(RPCInvalidRequest, "method must be a string")
+  	}
+  	switch r.Params.(type) {
+  	case nil, []interface{}, map[string]interface{}:
+  	default:
+  		return newErr(RPCInvalidRequest, "params must be an array or an object")
+  	}
+  	return nil
   }
 
   //Err represents error struct for response.
@@ -243,9 +342,29 @@
 
   //Response is for respoding to clinete in jsonrpc.
   type Response struct {
-  	Result interface{} `json:"result"`
-  	Error  *Err        `json:"error"`
-  	ID     interface{} `json:"id"`
+  	JSONRPC string      `json:"jsonrpc,omitempty"`
+  	Result  interface{} `json:"result"`
+  	Error   *Err        `json:"error"`
+  	ID      interface{} `json:"id"`
+  }
+
+  //MarshalJSON encodes r. A response in JSON-RPC 2.0 has either result or error,
+  //and one in 1.0 has both of them.
+  func (r Response) MarshalJSON() ([]byte, error) {
+  	type response Response
+  	if r.JSONRPC != "2.0" {
+  		return json.Marshal(response(r))
+  	}
+  	v := map[string]interface{}{
+  		"jsonrpc": r.JSONRPC,
+  		"id":      r.ID,
+  	}
+  	if r.Error != nil {
+  		v["error"] = r.Error
+  	} else {
+  		v["result"] = r.Result
+  	}
+  	return json.Marshal(v)
   }
 
   func isValidAuth(r *http.Request, conf *Conf) bool {
@@ -256,7 +375,9 @@
   	return username == conf.RPCUser && password == conf.RPCPassword
   }
 
-  //Handle handles api calls.
+  //Handle handles api calls in JSON-RPC 1.0 and 2.0.
+  //A JSON array of requests is handled as a batch, and responses are returned
+  //in an array in the same order. Notifications are not responded.
   func Handle(conf *Conf, w http.ResponseWriter, r *http.Request) {
   	defer func() {
   		if err := r.Body.Close(); err != nil {
@@ -272,60 +393,163 @@
   		}
   		return
   	}
-  	var req Request
-  	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+  	body, err := ioutil.ReadAll(r.Body)
+  	if err != nil {
   		http.Error(w, err.Error(), 400)
   		return
   	}
-  	res := Response{
-  		ID: req.ID,
+  	var res *Response
+  	var ress []*Response
+  	batch := false
+  	var reqs []json.RawMessage
+  	switch {
+  	case !json.Valid(body):
+  		res = &Response{
+  			Error: newErr(RPCParseError, "Parse error"),
+  		}
+  	case !isBatch(body):
+  		res = handleRaw(conf, body)
+  	case json.Unmarshal(body, &reqs) != nil || len(reqs) == 0:
+  		res = &Response{
+  			Error: newErr(RPCInvalidRequest, "batch must be a non-empty array"),
+  		}
+  	default:
+  		batch = true
+  		for _, raw := range reqs {
+  			if rs := handleRaw(conf, raw); rs != nil {
+  				ress = append(ress, rs)
+  			}
+  		}
+  	}
+  	if (batch && len(ress) == 0) || (!batch && res == nil) {
+  		w.WriteHeader(http.StatusNoContent)
+  		return
+  	}
+  	var result []byte
+  	status := http.StatusOK
+  	if batch {
+  		result, err = json.Marshal(ress)
+  	} else {
+  		if res.JSONRPC != "2.0" {
+  			status = httpStatus(res.Error)
+  		}
+  		result, err = json.Marshal(res)
+  	}
+  	if err != nil {
+  		http.Error(w, err.Error(), 400)
+  		return
+  	}
+  	w.Header().Set("Content-Type", "application/json")
+  	w.WriteHeader(status)
+  	if _, err := w.Write(result); err != nil {
+  		panic(err)
+  	}
+  }
+
+  func isBatch(body []byte) bool {
+  	body = bytes.TrimLeft(body, " \t\r\n")
+  	return len(body) > 0 && body[0] == '['
+  }
+
+  //handleRaw handles a request in JSON. It returns nil if the request is a notification.
+  func handleRaw(conf *Conf, raw []byte) *Response {
+  	var req Request
+  	if err := json.Unmarshal(raw, &req); err != nil {
+  		return &Response{
+  			Error: newErr(RPCInvalidRequest, err.Error()),
+  		}
+  	}
+  	if e := req.validate(); e != nil {
+  		res := &Response{
+  			Error: e,
+  			ID:    req.ID,
+  		}
+  		if req.JSONRPC == "2.0" {
+  			res.JSONRPC = req.JSONRPC
+  		}
+  		return res
+  	}
+  	res := handle(conf, &req)
+  	if req.isNotification() {
+  		return nil
+  	}
+  	return res
+  }
+
+  func handle(conf *Conf, req *Request) *Response {
+  	res := &Response{
+  		JSONRPC: req.JSONRPC,
+  		ID:      req.ID,
   	}
   	log.Println(req.Method, " is requested")
   	var err error
   	switch req.Method {
   	case "getnewaddress":
-  		err = getnewaddress(conf, &req, &res)
+  		err = getnewaddress(conf, req, res)
   	case "listaccounts":
-  		err = listaccounts(conf, &req, &res)
+  		err = listaccounts(conf, req, res)
   	case "listaddressgroupings":
-  		err = listaddressgroupings(conf, &req, &res)
+  		err = listaddressgroupings(conf, req, res)
   	case "validateaddress":
-  		err = validateaddress(conf, &req, &res)
+  		err = validateaddress(conf, req, res)
   	case "settxfee":
-  		err = settxfee(conf, &req, &res)
+  		err = settxfee(conf, req, res)
   	case "gettransaction":
-  		err = gettransaction(conf, &req, &res)
+  		err = gettransaction(conf, req, res)
   	case "getbalance":
-  		err = getbalance(conf, &req, &res)
+  		err = getbalance(conf, req, res)
+  	case "getunconfirmedbalance":
+  		err = getunconfirmedbalance(conf, req, res)
   	case "listtransactions":
-  		err = listtransactions(conf, &req, &res)
+  		err = listtransactions(conf, req, res)
+  	case "listsinceblock":
+  		err = listsinceblock(conf, req, res)
   	case "walletpassphrase":
-  		err = walletpassphrase(conf, &req, &res)
+  		err = walletpassphrase(conf, req, res)
+  	case "walletlock":
+  		err = walletlock(conf, req, res)
+  	case "walletpassphrasechange":
+  		err = walletpassphrasechange(conf, req, res)
+  	case "encryptwallet":
+  		err = encryptwallet(conf, req, res)
   	case "sendmany":
-  		err = sendmany(conf, &req, &res)
+  		err = sendmany(conf, req, res)
   	case "sendfrom":
-  		err = sendfrom(conf, &req, &res)
+  		err = sendfrom(conf, req, res)
   	case "sendtoaddress":
-  		err = sendtoaddress(conf, &req, &res)
+  		err = sendtoaddress(conf, req, res)
+  	case "getwalletinfo":
+  		err = getwalletinfo(conf, req, res)
+  	case "getblockchaininfo":
+  		err = getblockchaininfo(conf, req, res)
+  	case "getnetworkinfo":
+  		err = getnetworkinfo(conf, req, res)
+  	case "createrawtransaction":
+  		err = createrawtransaction(conf, req, res)
+  	case "fundrawtransaction":
+  		err = fundrawtransaction(conf, req, res)
+  	case "signrawtransaction":
+  		err = signrawtransaction(conf, req, res)
+  	case "sendrawtransaction":
+  		err = sendrawtransaction(conf, req, res)
+  	case "getpowinfo":
+  		err = getpowinfo(conf, req, res)
+  	case "listpendingtransfers":
+  		err = listpendingtransfers(conf, req, res)
+  	case "abandontransaction":
+  		err = abandontransaction(conf, req, res)
+  	case "importaddress":
+  		err = importaddress(conf, req, res)
   	case "importwallet":
-  		err = importwallet(conf, &req, &res)
+  		err = importwallet(conf, req, res)
   	default:
-  		err = errors.New(req.Method + " not supperted")
-  	}
-  	if err != nil {
-  		res.Error = &Err{
-  			Code:    -1,
-  			Message: err.Error(),
-  		}
+  		err = newErr(RPCMethodNotFound, "Method not found")
   	}
-  	result, err := json.Marshal(&res)
   	if err != nil {
-  		http.Error(w, err.Error(), 400)
-  		return
-  	}
-  	if _, err := w.Write(result); err != nil {
-  		panic(err)
+  		res.Result = nil
+  		res.Error = toErr(err)
   	}
+  	return res
   }
 
   //Prepare prepares aidosd.
@@ -337,6 +561,16 @@
   		Exit()
   		return nil, err
   	}
+  	if err := Migrate(); err != nil {
+  		fmt.Println(err)
+  		Exit()
+  		return nil, err
+  	}
+  	if err := db.View(loadAddressIndex); err != nil {
+  		fmt.Println(err)
+  		Exit()
+  		return nil, err
+  	}
   	conf := ParseConf(cfile)
 
   	return conf, nil
@@ -350,7 +584,13 @@
   			return err
   		}
   		for _, ac := range acs {
-  			log.Println("seed for", ac.Name, ":", ac.Seed)
+  			err = ac.withSeed(func(seed gadk.Trytes) error {
+  				log.Println("seed for", ac.Name, ":", seed)
+  				return nil
+  			})
+  			if err != nil {
+  				return err
+  			}
   		}
   		return nil
   	})
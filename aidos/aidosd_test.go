@@ -0,0 +1,261 @@
+  // Copyright (c) 2017 Aidos Developer
+
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+
+  package aidos
+
+  import (
+  	"bytes"
+  	"encoding/json"
+  	"net/http"
+  	"net/http/httptest"
+  	"testing"
+
+  	"github.com/AidosKuneen/gadk"
+  )
+
+  func post(t *testing.T, conf *Conf, body string) *httptest.ResponseRecorder {
+  	r := httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+  	r.SetBasicAuth(conf.RPCUser, conf.RPCPassword)
+  	w := httptest.NewRecorder()
+  	Handle(conf, w, r)
+  	return w
+  }
+
+  func TestHandleBatch(t *testing.T) {
+  	conf := prepareTest(t)
+  	w := post(t, conf, `[
+  	{"jsonrpc":"1.0","id":1,"method":"settxfee","params":[0.1]},
+  	{"jsonrpc":"1.0","id":2,"method":"nosuchmethod","params":[]},
+  	{"jsonrpc":"1.0","id":3,"method":3},
+  	{"jsonrpc":"1.0","id":4,"method":"settxfee","params":[0.1]}
+  	]`)
+  	if w.Code != http.StatusOK {
+  		t.Fatal("invalid status", w.Code)
+  	}
+  	var ress []Response
+  	if err := json.Unmarshal(w.Body.Bytes(), &ress); err != nil {
+  		t.Fatal(err)
+  	}
+  	if len(ress) != 4 {
+  		t.Fatal("invalid number of responses", len(ress))
+  	}
+  	for i, id := range []float64{1, 2, 0, 4} {
+  		if i != 2 && ress[i].ID != id {
+  			t.Error("invalid id", ress[i].ID, "should be", id)
+  		}
+  	}
+  	if ress[0].Error != nil || ress[0].Result != true {
+  		t.Error("settxfee should succeed", ress[0].Error)
+  	}
+  	if ress[1].Error == nil || ress[1].Result != nil {
+  		t.Error("unknown method should be error")
+  	}
+  	if ress[2].Error == nil {
+  		t.Error("malformed element should be error")
+  	}
+  	if ress[3].Error != nil || ress[3].Result != true {
+  		t.Error("settxfee should succeed", ress[3].Error)
+  	}
+  }
+
+  func TestHandleSingle(t *testing.T) {
+  	conf := prepareTest(t)
+  	w := post(t, conf, `{"jsonrpc":"1.0","id":"curltest","method":"settxfee","params":[0.1]}`)
+  	var res Response
+  	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+  		t.Fatal(err)
+  	}
+  	if res.ID != "curltest" || res.Result != true || res.Error != nil {
+  		t.Error("invalid response", res)
+  	}
+  	w = post(t, conf, `[]`)
+  	if w.Code != http.StatusBadRequest {
+  		t.Error("empty batch should be rejected", w.Code)
+  	}
+  }
+
+  func TestHandleErrors(t *testing.T) {
+  	conf := prepareTest(t)
+  	for _, c := range []struct {
+  		body   string
+  		status int
+  		code   int64
+  	}{
+  		{`{"id":1,"method":"nosuchmethod","params":[]}`, http.StatusNotFound, RPCMethodNotFound},
+  		{`{"id":1,"method":"validateaddress","params":[]}`, http.StatusInternalServerError, RPCInvalidParams},
+  		{`{"id":1,"method":"gettransaction","params":["` + string(gadk.EmptyHash) + `"]}`, http.StatusInternalServerError, RPCInvalidAddressOrKey},
+  		{`{"id":1,"method":"sendtoaddress","params":["` + string(gadk.EmptyHash) + `", 1]}`, http.StatusInternalServerError, RPCWalletUnlockNeeded},
+  		{`{"id":1,"method":"walletpassphrase","params":["invalid", 1]}`, http.StatusInternalServerError, RPCWalletPassphraseIncorrect},
+  	} {
+  		w := post(t, conf, c.body)
+  		if w.Code != c.status {
+  			t.Error("invalid status", w.Code, "should be", c.status, c.body)
+  		}
+  		var res Response
+  		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+  			t.Fatal(err)
+  		}
+  		if res.Error == nil || res.Error.Code != c.code {
+  			t.Error("invalid error", res.Error, "should be", c.code, c.body)
+  		}
+  		if res.Result != nil {
+  			t.Error("result should be nil")
+  		}
+  	}
+  }
+
+  func decodeFields(t *testing.T, b []byte) map[string]json.RawMessage {
+  	var fields map[string]json.RawMessage
+  	if err := json.Unmarshal(b, &fields); err != nil {
+  		t.Fatal(err, string(b))
+  	}
+  	return fields
+  }
+
+  func TestConformance(t *testing.T) {
+  	conf := prepareTest(t)
+  	for _, c := range []struct {
+  		name    string
+  		body    string
+  		status  int
+  		code    int64
+  		jsonrpc string
+  		id      string
+  	}{
+  		{"v2 result", `{"jsonrpc":"2.0","id":1,"method":"settxfee","params":[0.1]}`, http.StatusOK, 0, `"2.0"`, `1`},
+  		{"v2 named params", `{"jsonrpc":"2.0","id":"a","method":"settxfee","params":{"amount":0.1}}`, http.StatusOK, 0, `"2.0"`, `"a"`},
+  		{"v2 no params", `{"jsonrpc":"2.0","id":"a","method":"settxfee"}`, http.StatusOK, 0, `"2.0"`, `"a"`},
+  		{"v2 null id", `{"jsonrpc":"2.0","id":null,"method":"settxfee","params":[0.1]}`, http.StatusOK, 0, `"2.0"`, `null`},
+  		{"v2 unknown method", `{"jsonrpc":"2.0","id":1,"method":"nosuchmethod","params":[]}`, http.StatusOK, RPCMethodNotFound, `"2.0"`, `1`},
+  		{"v2 invalid params", `{"jsonrpc":"2.0","id":1,"method":"validateaddress","params":[]}`, http.StatusOK, RPCInvalidParams, `"2.0"`, `1`},
+  		{"v2 no method", `{"jsonrpc":"2.0","id":1,"params":[]}`, http.StatusOK, RPCInvalidRequest, `"2.0"`, `1`},
+  		{"v2 invalid method", `{"jsonrpc":"2.0","id":1,"method":1,"params":[]}`, http.StatusBadRequest, RPCInvalidRequest, ``, `null`},
+  		{"v2 invalid params type", `{"jsonrpc":"2.0","id":1,"method":"settxfee","params":"0.1"}`, http.StatusOK, RPCInvalidRequest, `"2.0"`, `1`},
+  		{"v1 result", `{"jsonrpc":"1.0","id":1,"method":"settxfee","params":[0.1]}`, http.StatusOK, 0, `"1.0"`, `1`},
+  		{"v1 without version", `{"id":1,"method":"settxfee","params":[0.1]}`, http.StatusOK, 0, ``, `1`},
+  		{"v1 unknown method", `{"id":1,"method":"nosuchmethod","params":[]}`, http.StatusNotFound, RPCMethodNotFound, ``, `1`},
+  		{"v1 no method", `{"id":1,"params":[]}`, http.StatusBadRequest, RPCInvalidRequest, ``, `1`},
+  		{"invalid version", `{"jsonrpc":"3.0","id":1,"method":"settxfee","params":[]}`, http.StatusBadRequest, RPCInvalidRequest, ``, `1`},
+  		{"parse error", `{"jsonrpc":"2.0","id":1,"method":"settxfee",`, http.StatusInternalServerError, RPCParseError, ``, `null`},
+  		{"not an object", `1`, http.StatusBadRequest, RPCInvalidRequest, ``, `null`},
+  		{"empty batch", `[]`, http.StatusBadRequest, RPCInvalidRequest, ``, `null`},
+  	} {
+  		w := post(t, conf, c.body)
+  		if w.Code != c.status {
+  			t.Error(c.name, ": invalid status", w.Code, "should be", c.status)
+  		}
+  		fields := decodeFields(t, w.Body.Bytes())
+  		if string(fields["id"]) != c.id {
+  			t.Error(c.name, ": invalid id", string(fields["id"]), "should be", c.id)
+  		}
+  		if string(fields["jsonrpc"]) != c.jsonrpc {
+  			t.Error(c.name, ": invalid jsonrpc", string(fields["jsonrpc"]), "should be", c.jsonrpc)
+  		}
+  		_, hasResult := fields["result"]
+  		_, hasError := fields["error"]
+  		if c.jsonrpc == `"2.0"` && hasResult == hasError {
+  			t.Error(c.name, ": response must have either result or error", w.Body.String())
+  		}
+  		if c.jsonrpc != `"2.0"` && (!hasResult || !hasError) {
+  			t.Error(c.name, ": response must have both result and error", w.Body.String())
+  		}
+  		var res Response
+  		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+  			t.Fatal(err)
+  		}
+  		if c.code == 0 && (res.Error != nil || res.Result != true) {
+  			t.Error(c.name, ": should succeed", res.Error)
+  		}
+  		if c.code != 0 && (res.Error == nil || res.Error.Code != c.code) {
+  			t.Error(c.name, ": invalid error", res.Error, "should be", c.code)
+  		}
+  	}
+  }
+
+  func TestNotification(t *testing.T) {
+  	conf := prepareTest(t)
+  	for _, body := range []string{
+  		`{"jsonrpc":"2.0","method":"settxfee","params":[0.1]}`,
+  		`{"jsonrpc":"2.0","method":"nosuchmethod","params":[]}`,
+  		`[{"jsonrpc":"2.0","method":"settxfee","params":[0.1]},{"jsonrpc":"2.0","method":"settxfee"}]`,
+  	} {
+  		w := post(t, conf, body)
+  		if w.Code != http.StatusNoContent {
+  			t.Error("invalid status", w.Code, body)
+  		}
+  		if w.Body.Len() != 0 {
+  			t.Error("notification must not be responded", w.Body.String())
+  		}
+  	}
+  	//requests in JSON-RPC 1.0 are always responded.
+  	for _, body := range []string{
+  		`{"jsonrpc":"1.0","id":null,"method":"settxfee","params":[0.1]}`,
+  		`{"method":"settxfee","params":[0.1]}`,
+  	} {
+  		w := post(t, conf, body)
+  		if w.Code != http.StatusOK {
+  			t.Error("invalid status", w.Code, body)
+  		}
+  		if string(decodeFields(t, w.Body.Bytes())["id"]) != "null" {
+  			t.Error("invalid response", w.Body.String())
+  		}
+  	}
+
+  	w := post(t, conf, `[
+  	{"jsonrpc":"2.0","method":"settxfee","params":[0.1]},
+  	{"jsonrpc":"2.0","id":1,"method":"settxfee","params":[0.1]},
+  	1,
+  	{"jsonrpc":"2.0","method":"nosuchmethod"},
+  	{"jsonrpc":"2.0","id":2,"method":"nosuchmethod"}
+  	]`)
+  	if w.Code != http.StatusOK {
+  		t.Error("invalid status", w.Code)
+  	}
+  	var ress []json.RawMessage
+  	if err := json.Unmarshal(w.Body.Bytes(), &ress); err != nil {
+  		t.Fatal(err)
+  	}
+  	if len(ress) != 3 {
+  		t.Fatal("invalid number of responses", len(ress), w.Body.String())
+  	}
+  	for i, c := range []struct {
+  		id   string
+  		code int64
+  	}{
+  		{`1`, 0},
+  		{`null`, RPCInvalidRequest},
+  		{`2`, RPCMethodNotFound},
+  	} {
+  		fields := decodeFields(t, ress[i])
+  		if string(fields["id"]) != c.id {
+  			t.Error("invalid id", string(fields["id"]), "should be", c.id)
+  		}
+  		var res Response
+  		if err := json.Unmarshal(ress[i], &res); err != nil {
+  			t.Fatal(err)
+  		}
+  		if c.code == 0 && res.Error != nil {
+  			t.Error("should succeed", res.Error)
+  		}
+  		if c.code != 0 && (res.Error == nil || res.Error.Code != c.code) {
+  			t.Error("invalid error", res.Error, "should be", c.code)
+  		}
+  	}
+  }
@@ -0,0 +1,121 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+
+  import (
+  	"encoding/json"
+  	"fmt"
+  	"math/big"
+  	"strconv"
+  	"strings"
+  )
+
+  //Amount is an amount of ADK in the smallest unit (10^-8 ADK).
+  //It is parsed from and marshalled to JSON numbers with up to 8 decimals exactly, without errors of float64.
+  type Amount int64
+
+  //unitsPerADK is the number of the smallest units in 1 ADK.
+  const unitsPerADK = 100000000
+
+  var (
+  	errInvalidAmount    = newErr(RPCTypeError, "Invalid amount")
+  	errAmountOutOfRange = newErr(RPCTypeError, "Amount out of range")
+  )
+
+  //parseAmount parses s, a decimal number of ADK, which may be negative.
+  func parseAmount(s string) (Amount, error) {
+  	//big.Rat accepts fractions and hexadecimals too.
+  	if s == "" || strings.Trim(s, "0123456789.-+eE") != "" {
+  		return 0, errInvalidAmount
+  	}
+  	//a huge exponent makes a huge number.
+  	if i := strings.IndexAny(s, "eE"); i >= 0 {
+  		if e, err := strconv.Atoi(s[i+1:]); err != nil || e > 20 || e < -20 {
+  			return 0, errInvalidAmount
+  		}
+  	}
+  	r, ok := new(big.Rat).SetString(s)
+  	if !ok {
+  		return 0, errInvalidAmount
+  	}
+  	r.Mul(r, big.NewRat(unitsPerADK, 1))
+  	if !r.IsInt() {
+  		return 0, errInvalidAmount //more than 8 decimals
+  	}
+  	if !r.Num().IsInt64() {
+  		return 0, errAmountOutOfRange
+  	}
+  	return Amount(r.Num().Int64()), nil
+  }
+
+  //toAmount converts p in params, a JSON number (or a string of it), to a non-negative amount.
+  func toAmount(p interface{}) (Amount, error) {
+  	var s string
+  	switch n := p.(type) {
+  	case json.Number:
+  		s = n.String()
+  	case string:
+  		s = n
+  	case float64:
+  		//the shortest representation, which is what the caller wrote.
+  		s = strconv.FormatFloat(n, 'f', -1, 64)
+  	case int:
+  		s = strconv.Itoa(n)
+  	case int64:
+  		s = strconv.FormatInt(n, 10)
+  	default:
+  		return 0, newErr(RPCTypeError, "Amount is not a number or string")
+  	}
+  	a, err := parseAmount(s)
+  	if err != nil {
+  		return 0, err
+  	}
+  	if a < 0 {
+  		return 0, errAmountOutOfRange
+  	}
+  	return a, nil
+  }
+
+  //String returns a in ADK with 8 decimals.
+  func (a Amount) String() string {
+  	sign := ""
+  	u := uint64(a)
+  	if a < 0 {
+  		sign = "-"
+  		u = uint64(-a)
+  	}
+  	return fmt.Sprintf("%s%d.%08d", sign, u/unitsPerADK, u%unitsPerADK)
+  }
+
+  //MarshalJSON marshals a to a JSON number in ADK with 8 decimals.
+  func (a Amount) MarshalJSON() ([]byte, error) {
+  	return []byte(a.String()), nil
+  }
+
+  //UnmarshalJSON parses a JSON number in ADK.
+  func (a *Amount) UnmarshalJSON(b []byte) error {
+  	n, err := parseAmount(string(b))
+  	if err != nil {
+  		return err
+  	}
+  	*a = n
+  	return nil
+  }
@@ -0,0 +1,121 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+
+  import (
+  	"encoding/json"
+  	"testing"
+  )
+
+  func TestAmount(t *testing.T) {
+  	tests := []struct {
+  		in  interface{}
+  		out Amount
+  		err bool
+  	}{
+  		{json.Number("0.29"), 29000000, false},
+  		{json.Number("1"), 100000000, false},
+  		{json.Number("0.00000001"), 1, false},
+  		{json.Number("1e-8"), 1, false},
+  		{json.Number("2.5E2"), 25000000000, false},
+  		{json.Number("92233720368.54775807"), 9223372036854775807, false},
+  		{json.Number("92233720368.54775808"), 0, true},
+  		{json.Number("0.000000001"), 0, true},
+  		{json.Number("-0.1"), 0, true},
+  		{json.Number("1e-100"), 0, true},
+  		{json.Number("1e100000000"), 0, true},
+  		{"0.1", 10000000, false},
+  		{"0x10", 0, true},
+  		{"1/2", 0, true},
+  		{"", 0, true},
+  		{0.29, 29000000, false},
+  		{1.1, 110000000, false},
+  		{3, 300000000, false},
+  		{true, 0, true},
+  	}
+  	for _, tt := range tests {
+  		a, err := toAmount(tt.in)
+  		if tt.err != (err != nil) {
+  			t.Error(tt.in, "invalid error", err)
+  		}
+  		if a != tt.out {
+  			t.Error(tt.in, "invalid amount", a, tt.out)
+  		}
+  	}
+
+  	for _, tt := range []struct {
+  		in  Amount
+  		out string
+  	}{
+  		{29000000, "0.29000000"},
+  		{-1, "-0.00000001"},
+  		{0, "0.00000000"},
+  		{-123456789012, "-1234.56789012"},
+  	} {
+  		b, err := json.Marshal(tt.in)
+  		if err != nil {
+  			t.Fatal(err)
+  		}
+  		if string(b) != tt.out {
+  			t.Error("invalid json", string(b), tt.out)
+  		}
+  		var a Amount
+  		if err := json.Unmarshal(b, &a); err != nil {
+  			t.Fatal(err)
+  		}
+  		if a != tt.in {
+  			t.Error("invalid unmarshalled amount", a, tt.in)
+  		}
+  	}
+  }
+
+  func TestAmountParams(t *testing.T) {
+  	var req Request
+  	if err := json.Unmarshal([]byte(`{"method":"sendtoaddress","params":["ADDRESS",0.29]}`), &req); err != nil {
+  		t.Fatal(err)
+  	}
+  	var p sendtoaddressParams
+  	if err := parseParams(&req, &p); err != nil {
+  		t.Fatal(err)
+  	}
+  	if p.Amount != 29000000 {
+  		t.Error("invalid amount", p.Amount)
+  	}
+  	for _, params := range []string{`["ADDRESS",0.123456789]`, `["ADDRESS",-1]`, `["ADDRESS",true]`} {
+  		if err := json.Unmarshal([]byte(`{"method":"sendtoaddress","params":`+params+`}`), &req); err != nil {
+  			t.Fatal(err)
+  		}
+  		if err := parseParams(&req, &p); err == nil {
+  			t.Error("should be error", params)
+  		}
+  	}
+
+  	trs, err := toTransfers(&Conf{}, `{"A":0.29,"B":"1.00000001"}`)
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	if len(trs) != 2 || trs[0].Value != 29000000 || trs[1].Value != 100000001 {
+  		t.Error("invalid transfers", trs)
+  	}
+  	if _, err := toTransfers(&Conf{}, map[string]interface{}{"A": json.Number("0.000000001")}); err == nil {
+  		t.Error("should be error")
+  	}
+  }
@@ -55,8 +55,8 @@
   	os.Exit(code)
   }
   
-  func prepareTest(t *testing.T) *Conf {
-  	lastAccount = nil
+  func prepareTest(t testing.TB) *Conf {
+  	unlocker.lock()
   	if db != nil {
   		if err := db.Close(); err != nil {
   			t.Log(err)
@@ -86,14 +86,18 @@
   	d1 := newdummy(acc, t)
   	conf.api = d1
   
-  	testListAccounts(conf, d1)
-  	testlistaddressgroupings(conf, d1)
   	testvalidateaddress1(conf, d1, "HZSMDORPCAFJJJNEEWZSP9OCQZAHCAVPBAXUTJKRCYZXMSNGERFZLQPNWOQQHK9RMJO9PNSVV9KR9DONH", true)
   	testvalidateaddress1(conf, d1, "ZSMDORPCAFJJJNEEWZSP9OCQZAHCAVPBAXUTJKRCYZXMSNGERFZLQPNWOQQHK9RMJO9PNSVV9KR9DONH", false)
   	testvalidateaddress2(conf, d1)
   	if _, err := Walletnotify(conf); err != nil {
   		t.Error(err)
   	}
+  	testListAccounts(conf, d1)
+  	testlistaddressgroupings(conf, d1)
+  	testgetunconfirmedbalance(conf, d1)
+  	ntx := len(d1.listall()) + len(d1.bundle)
+  	last := testlistsinceblock(conf, d1, "", ntx)
+  	testlistsinceblock(conf, d1, last, 0)
   	for ac := range d1.acc2adr {
   		testgetbalance(conf, d1, ac)
   		testlisttransactions(conf, d1, ac)
@@ -105,6 +109,8 @@
   	for ac := range d1.acc2adr {
   		testlisttransactions(conf, d1, ac)
   	}
+  	last2 := testlistsinceblock(conf, d1, last, ntx)
+  	testlistsinceblock(conf, d1, last2, 0)
   	testgetbalance2(conf, d1)
   	testlisttransactions2(conf, d1)
   	testgettransaction(conf, d1)
@@ -147,15 +153,15 @@
   		if !ok {
   			d1.t.Error("result[0][i][2] must be string")
   		}
-  		v, ok := d1.vals[adr]
-  		if !ok {
+  		if _, ok := d1.vals[adr]; !ok {
   			d1.t.Error("invalid adrress")
   		}
-  		val, ok := result[0][i][1].(float64)
+  		v, _ := d1.balance(adr)
+  		val, ok := result[0][i][1].(Amount)
   		if !ok {
-  			d1.t.Error("result[0][i][1] must be float")
+  			d1.t.Error("result[0][i][1] must be Amount")
   		}
-  		if float64(v)/100000000 != val {
+  		if Amount(v) != val {
   			d1.t.Error("invalid value")
   		}
   		acc2, ok := d1.adr2acc[adr]
@@ -328,7 +334,7 @@
   		if d.Amount < 0 && d.Category != "send" {
   			d1.t.Error("invalid category")
   		}
-  		if d.Amount != float64(d1.bundle[i].Value)/100000000 {
+  		if d.Amount != Amount(d1.bundle[i].Value) {
   			d1.t.Error("invalid amount", d.Amount, d1.bundle[i].Value, adr)
   		}
   		if d.Fee != 0 {
@@ -358,17 +364,54 @@
   	if resp.Error != nil {
   		d1.t.Error(resp.Error)
   	}
-  	result, ok := resp.Result.(float64)
+  	result, ok := resp.Result.(Amount)
   	if !ok {
-  		d1.t.Error("result must be float64")
+  		d1.t.Error("result must be Amount")
   	}
-  	var total int64
+  	var total, pending int64
   	for _, a := range d1.acc2adr[ac] {
-  		total += d1.vals[a]
+  		c, p := d1.balance(a)
+  		total += c
+  		pending += p
   	}
-  	if result != float64(total)/100000000 {
+  	if result != Amount(total) {
   		d1.t.Error("invalid balance", result, ac, total, len(d1.acc2adr[""]))
   	}
+
+  	//pending values are included if minconf is 0.
+  	req.Params = []interface{}{ac, float64(0)}
+  	resp = Response{}
+  	if err := getbalance(conf, req, &resp); err != nil {
+  		d1.t.Error(err)
+  	}
+  	if resp.Result != Amount(total+pending) {
+  		d1.t.Error("invalid balance with minconf 0", resp.Result, ac, total+pending)
+  	}
+  	req.Params = []interface{}{ac, float64(-1)}
+  	if err := getbalance(conf, req, &resp); err == nil {
+  		d1.t.Error("negative minconf should be error")
+  	}
+  }
+
+  func testgetunconfirmedbalance(conf *Conf, d1 *dummy1) {
+  	req := &Request{
+  		JSONRPC: "1.0",
+  		ID:      "curltest",
+  		Method:  "getunconfirmedbalance",
+  		Params:  []interface{}{},
+  	}
+  	var resp Response
+  	if err := getunconfirmedbalance(conf, req, &resp); err != nil {
+  		d1.t.Error(err)
+  	}
+  	var pending int64
+  	for adr := range d1.adr2acc {
+  		_, p := d1.balance(adr)
+  		pending += p
+  	}
+  	if resp.Result != Amount(pending) {
+  		d1.t.Error("invalid unconfirmed balance", resp.Result, pending)
+  	}
   }
   
   func testgetbalance2(conf *Conf, d1 *dummy1) {
@@ -386,15 +429,15 @@
   	if resp.Error != nil {
   		d1.t.Error(resp.Error)
   	}
-  	result, ok := resp.Result.(float64)
+  	result, ok := resp.Result.(Amount)
   	if !ok {
-  		d1.t.Error("result must be float64")
+  		d1.t.Error("result must be Amount")
   	}
   	var total int64
   	for _, v := range d1.vals {
   		total += v
   	}
-  	if result != float64(total)/100000000 {
+  	if result != Amount(total) {
   		d1.t.Error("invalid balance", result, total, len(d1.acc2adr[""]))
   	}
   }
@@ -443,8 +486,8 @@
   		if tx.Amount == 0 {
   			d1.t.Error(" amount should not be 0")
   		}
-  		if tx.Amount != float64(otx.Value)/100000000 {
-  			d1.t.Error("invalid amount", int64(tx.Amount*100000000), otx.Value, ac)
+  		if tx.Amount != Amount(otx.Value) {
+  			d1.t.Error("invalid amount", tx.Amount, otx.Value, ac)
   		}
   		if tx.Time != otx.Timestamp.Unix() {
   			d1.t.Error("invalid time")
@@ -536,7 +579,7 @@
   		if tx.Amount == 0 {
   			d1.t.Error(" amount should not be 0")
   		}
-  		if tx.Amount != float64(otx.Value)/100000000 {
+  		if tx.Amount != Amount(otx.Value) {
   			d1.t.Error("invalid amount", tx.Amount)
   		}
   		if tx.Time != otx.Timestamp.Unix() {
@@ -584,6 +627,52 @@
   	}
   }
   
+  func testlistsinceblock(conf *Conf, d1 *dummy1, since string, n int) string {
+  	req := &Request{
+  		JSONRPC: "1.0",
+  		ID:      "curltest",
+  		Method:  "listsinceblock",
+  		Params:  []interface{}{since},
+  	}
+
+  	var resp Response
+  	if err := listsinceblock(conf, req, &resp); err != nil {
+  		d1.t.Error(err)
+  	}
+  	result, ok := resp.Result.(*sinceblock)
+  	if !ok {
+  		d1.t.Fatal("result must be sinceblock struct")
+  	}
+  	if len(result.Transactions) != n {
+  		d1.t.Error("invalid number of txs", len(result.Transactions), n)
+  	}
+  	if n > 0 && result.Lastblock == since {
+  		d1.t.Error("lastblock should be updated", result.Lastblock)
+  	}
+  	for _, tx := range result.Transactions {
+  		conf := 100000
+  		if !d1.isConf {
+  			conf = 0
+  		}
+  		if tx.Confirmations != conf {
+  			d1.t.Error("invalid confirmations")
+  		}
+  	}
+  	req.Params = []interface{}{since, float64(confirmedConfirmations + 1)}
+  	resp = Response{}
+  	if err := listsinceblock(conf, req, &resp); err != nil {
+  		d1.t.Error(err)
+  	}
+  	if r, ok := resp.Result.(*sinceblock); !ok || r.Lastblock != result.Lastblock || len(r.Transactions) != n {
+  		d1.t.Error("lastblock should be the current cursor even if no tx reaches target_confirmations", resp.Result)
+  	}
+  	req.Params = []interface{}{"invalid"}
+  	if err := listsinceblock(conf, req, &resp); err == nil {
+  		d1.t.Error("should be error")
+  	}
+  	return result.Lastblock
+  }
+
   func testListAccounts(conf *Conf, d1 *dummy1) {
   	req := &Request{
   		JSONRPC: "1.0",
@@ -598,7 +687,7 @@
   	if resp.Error != nil {
   		d1.t.Error(resp.Error)
   	}
-  	result, ok := resp.Result.(map[string]float64)
+  	result, ok := resp.Result.(map[string]Amount)
   	if !ok {
   		d1.t.Error("result must be map")
   	}
@@ -608,11 +697,12 @@
   	total := make(map[string]int64)
   	for ac, as := range d1.acc2adr {
   		for _, a := range as {
-  			total[ac] += d1.vals[a]
+  			c, _ := d1.balance(a)
+  			total[ac] += c
   		}
   	}
   	for ac := range d1.acc2adr {
-  		if result[ac] != float64(total[ac])/100000000 {
+  		if result[ac] != Amount(total[ac]) {
   			d1.t.Error("invalid balance", ac, result[ac], "must be", total[ac])
   		}
   	}
@@ -21,29 +21,26 @@
   package aidos
 
   import (
-  	"errors"
   	"github.com/AidosKuneen/gadk"
   	"github.com/boltdb-go/bolt"
   	"log"
+  	"strconv"
   )
 
+  type importwalletParams struct {
+  	Seed string `param:"seed,required"`
+  }
+
   func importwallet(conf *Conf, req *Request, res *Response) error {
   	mutex.Lock()
   	defer mutex.Unlock()
-  	data, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("invalid params")
-  	}
-  	if len(data) != 1 {
-  		return errors.New("invalid param length")
-  	}
-  	seed, ok := data[0].(string)
-  	if !ok {
-  		return errors.New("invalid seed")
+  	var p importwalletParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
   	}
 
   	log.Println("restoring from a seed...")
-  	if seedTrytes, err := gadk.ToTrytes(seed); err == nil {
+  	if seedTrytes, err := gadk.ToTrytes(p.Seed); err == nil {
   		err := RestoreAddressesFromSeed(conf, seedTrytes)
   		if err != nil {
   			log.Printf("Error restoring from the seed: %v\n", err)
@@ -55,43 +52,38 @@
   	} else {
   		log.Printf("Error parsing the seed: %v\n", err)
 
-  		return err
+  		return newErr(RPCInvalidAddressOrKey, err.Error())
   	}
 
   	return nil
   }
 
+  type getnewaddressParams struct {
+  	Account string `param:"account|label"`
+  }
+
   func getnewaddress(conf *Conf, req *Request, res *Response) error {
   	mutex.Lock()
   	defer mutex.Unlock()
-  	data, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("invalid params")
-  	}
-  	acc := ""
-  	switch len(data) {
-  	case 1:
-  		acc, ok = data[0].(string)
-  		if !ok {
-  			return errors.New("invalid txid")
-  		}
-  	case 0:
-  	default:
-  		return errors.New("invalid params")
+  	var p getnewaddressParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
   	}
   	return db.Update(func(tx *bolt.Tx) error {
-      ac, err := getAccount(tx, acc)
+      ac, err := getAccount(tx, p.Account)
   		if err != nil {
   			return err
   		}
   		if ac == nil {
-  			ac = &Account{
-  				Name: acc,
-  				Seed: gadk.NewSeed(),
-  			}
+  			ac = newAccount(p.Account, gadk.NewSeed())
   		}
-  		// TODO Move "2" magic number to a constant
-  		adr, err := gadk.NewAddress(ac.Seed, len(ac.Balances), 2)
+  		var adr gadk.Address
+  		err = ac.withSeed(func(seed gadk.Trytes) error {
+  			var err error
+  			// TODO Move "2" magic number to a constant
+  			adr, err = gadk.NewAddress(seed, len(ac.Balances), 2)
+  			return err
+  		})
   		if err != nil {
   			return err
   		}
@@ -104,33 +96,23 @@
   		return putAccount(tx, ac)
   	})
   }
-  func getBalance(api apis, tx *bolt.Tx) ([]Account, map[gadk.Address]int64, error) {
-  	mutex.RLock()
-  	defer mutex.RUnlock()
-  	acs, err := listAccount(tx)
-  	if err != nil {
-  		return nil, nil, err
-  	}
-  	var address []gadk.Address
-  	for _, ac := range acs {
-  		for _, b := range ac.Balances {
-  			address = append(address, b.Address)
-  		}
-  	}
-  	bals, err := api.Balances(address)
-  	balmap := make(map[gadk.Address]int64)
-  	for _, b := range bals {
-  		balmap[b.Address] = b.Value
+
+  //checkMinconf returns an error if minconf is negative.
+  //Confirmations are regarded as final, so minconf larger than 1 is same as 1.
+  func checkMinconf(minconf int) error {
+  	if minconf < 0 {
+  		return newErr(RPCInvalidParameter, "minconf must not be negative")
   	}
-  	return acs, balmap, err
+  	return nil
   }
+
   func listaddressgroupings(conf *Conf, req *Request, res *Response) error {
   	mutex.RLock()
   	defer mutex.RUnlock()
   	var result [][][]interface{}
   	var r0 [][]interface{}
   	err := db.View(func(tx *bolt.Tx) error {
-  		acs, balmap, err := getBalance(conf.api, tx)
+  		acs, balmap, err := getBalance(tx)
   		if err != nil {
   			return err
   		}
@@ -138,10 +120,7 @@
   			for _, b := range ac.Balances {
   				r1 := make([]interface{}, 3)
   				r1[0] = b.Address.WithChecksum()
-  				r1[1] = 0
-  				if v, ok := balmap[b.Address]; ok {
-  					r1[1] = float64(v) / 100000000
-  				}
+  				r1[1] = Amount(balmap[b.Address].Confirmed)
   				r1[2] = ac.Name
   				r0 = append(r0, r1)
   			}
@@ -152,99 +131,113 @@
   	res.Result = result
   	return err
   }
+
+  type getbalanceParams struct {
+  	Account          string `param:"account|dummy"`
+  	Minconf          int    `param:"minconf"`
+  	IncludeWatchonly bool   `param:"include_watchonly"`
+  }
+
+  //getbalance returns the confirmed balance of the account (or all accounts if "*"),
+  //including pending incoming values if minconf is 0.
   func getbalance(conf *Conf, req *Request, res *Response) error {
   	mutex.RLock()
   	defer mutex.RUnlock()
-  	data, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("param must be slice")
-  	}
-  	adrstr := "*"
-  	switch len(data) {
-  	case 3:
-  		fallthrough
-  	case 2:
-  		n, okk := data[1].(float64)
-  		if !okk {
-  			return errors.New("invalid number")
-  		}
-  		if n == 0 {
-  			return errors.New("not support unconfirmed transactions")
-  		}
-  		fallthrough
-  	case 1:
-  		adrstr, ok = data[0].(string)
-  		if !ok {
-  			return errors.New("invalid address")
-  		}
-  	case 0:
-  	default:
-  		return errors.New("invalid params")
+  	p := getbalanceParams{
+  		Account: "*",
+  		Minconf: 1,
+  	}
+  	if err := defaultIncludeWatchonly(&p.IncludeWatchonly); err != nil {
+  		return err
+  	}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
   	}
+  	if err := checkMinconf(p.Minconf); err != nil {
+  		return err
+  	}
+  	adrstr := p.Account
 
   	err := db.View(func(tx *bolt.Tx) error {
-  		acc, balmap, err := getBalance(conf.api, tx)
+  		acc, balmap, err := getBalance(tx)
   		if err != nil {
   			return err
   		}
   		var total int64
-  		if adrstr == "*" {
-  			for _, v := range balmap {
-  				total += v
+  		for _, a := range acc {
+  			if adrstr != "*" && adrstr != a.Name {
+  				continue
   			}
-  		} else {
-  			var bal []Balance
-  			for _, a := range acc {
-  				if adrstr == a.Name {
-  					bal = a.Balances
-  				}
+  			if a.WatchOnly && !p.IncludeWatchonly {
+  				continue
   			}
-  			for _, b := range bal {
-  				total += balmap[b.Address]
+  			for _, b := range a.Balances {
+  				total += balmap[b.Address].total(p.Minconf)
   			}
   		}
-  		ftotal := float64(total) / 100000000
-  		res.Result = ftotal
+  		res.Result = Amount(total)
   		return nil
   	})
   	return err
   }
-  func listaccounts(conf *Conf, req *Request, res *Response) error {
+
+  //getunconfirmedbalance returns the sum of pending incoming values of all accounts.
+  func getunconfirmedbalance(conf *Conf, req *Request, res *Response) error {
+  	var p struct{}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
   	mutex.RLock()
   	defer mutex.RUnlock()
-  	ary, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("invalid param")
-  	}
-  	if len(ary) > 0 {
-  		conf, ok := ary[0].(float64)
-  		if !ok {
-  			return errors.New("invalid param")
+  	return db.View(func(tx *bolt.Tx) error {
+  		_, balmap, err := getBalance(tx)
+  		if err != nil {
+  			return err
   		}
-  		if conf == 0 {
-  			return errors.New("not support unconfirmed transacton")
+  		var total int64
+  		for _, v := range balmap {
+  			total += v.Pending
   		}
+  		res.Result = Amount(total)
+  		return nil
+  	})
+  }
+
+  type listaccountsParams struct {
+  	Minconf          int  `param:"minconf"`
+  	IncludeWatchonly bool `param:"include_watchonly"`
+  }
+
+  func listaccounts(conf *Conf, req *Request, res *Response) error {
+  	mutex.RLock()
+  	defer mutex.RUnlock()
+  	p := listaccountsParams{
+  		Minconf: 1,
+  	}
+  	if err := defaultIncludeWatchonly(&p.IncludeWatchonly); err != nil {
+  		return err
+  	}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	if err := checkMinconf(p.Minconf); err != nil {
+  		return err
   	}
-  	result := make(map[string]float64)
+  	result := make(map[string]Amount)
   	err := db.View(func(tx *bolt.Tx) error {
-  		acs, err := listAccount(tx)
+  		acs, balmap, err := getBalance(tx)
   		if err != nil {
   			return err
   		}
   		for _, ac := range acs {
-  			var addresses []gadk.Address
-  			for _, b := range ac.Balances {
-  				addresses = append(addresses, b.Address)
-  			}
-  			bals, err := conf.api.Balances(addresses)
-  			if err != nil {
-  				return err
+  			if ac.WatchOnly && !p.IncludeWatchonly {
+  				continue
   			}
   			var sum int64
-  			for _, b := range bals {
-  				sum += b.Value
+  			for _, b := range ac.Balances {
+  				sum += balmap[b.Address].total(p.Minconf)
   			}
-  			result[ac.Name] = float64(sum) / 100000000
+  			result[ac.Name] = Amount(sum)
   		}
   		return nil
   	})
@@ -264,21 +257,19 @@
   	Account      *string `json:"account,omitempty"`
   }
 
+  type validateaddressParams struct {
+  	Address string `param:"address,required"`
+  }
+
   //only 'isvalid' params is valid, others may be incorrect.
   func validateaddress(conf *Conf, req *Request, res *Response) error {
   	mutex.RLock()
   	defer mutex.RUnlock()
-  	data, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("invalid params")
-  	}
-  	if len(data) != 1 {
-  		return errors.New("length of param must be 1")
-  	}
-  	adrstr, ok := data[0].(string)
-  	if !ok {
-  		return errors.New("invalid address")
+  	var p validateaddressParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
   	}
+  	adrstr := p.Address
   	valid := false
   	adr, err := gadk.ToAddress(adrstr)
   	if err == nil {
@@ -298,9 +289,10 @@
   	t := false
   	empty := ""
   	if ac != nil {
-  		infoi.IsMine = true
+  		watchOnly := ac.WatchOnly
+  		infoi.IsMine = !watchOnly
   		infoi.Account = &ac.Name
-  		infoi.IsWatchOnly = &t
+  		infoi.IsWatchOnly = &watchOnly
   		infoi.IsScript = &t
   		infoi.Pubkey = &empty
   		infoi.IsCompressed = &t
@@ -309,7 +301,40 @@
   	return nil
   }
 
+  type importaddressParams struct {
+  	Address string `param:"address,required"`
+  	Label   string `param:"label"`
+  	Rescan  bool   `param:"rescan"`
+  	P2SH    bool   `param:"p2sh"`
+  }
+
+  func importaddress(conf *Conf, req *Request, res *Response) error {
+  	mutex.Lock()
+  	defer mutex.Unlock()
+  	p := importaddressParams{
+  		Rescan: true,
+  	}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	adr, err := gadk.ToAddress(p.Address)
+  	if err != nil {
+  		return newErr(RPCInvalidAddressOrKey, "Invalid address: "+err.Error())
+  	}
+  	return db.Update(func(tx *bolt.Tx) error {
+  		return importAddress(tx, p.Label, adr)
+  	})
+  }
+
+  type settxfeeParams struct {
+  	Amount Amount `param:"amount"`
+  }
+
   func settxfee(conf *Conf, req *Request, res *Response) error {
+  	var p settxfeeParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
   	res.Result = true
   	return nil
   }
@@ -318,15 +343,15 @@
   	Account   string      `json:"account"`
   	Address   gadk.Trytes `json:"address"`
   	Category  string      `json:"category"`
-  	Amount    float64     `json:"amount"`
+  	Amount    Amount      `json:"amount"`
   	Vout      int64       `json:"vout"`
-  	Fee       float64     `json:"fee"`
+  	Fee       Amount      `json:"fee"`
   	Abandoned *bool       `json:"abandoned,omitempty"`
   }
 
   type tx struct {
-  	Amount            float64     `json:"amount"`
-  	Fee               float64     `json:"fee"`
+  	Amount            Amount      `json:"amount"`
+  	Fee               Amount      `json:"fee"`
   	Confirmations     int         `json:"confirmations"`
   	Blockhash         *string     `json:"blockhash,omitempty"`
   	Blockindex        *int64      `json:"blockindex,omitempty"`
@@ -340,90 +365,79 @@
   	Hex               string      `json:"hex"`
   }
 
+  type gettransactionParams struct {
+  	Txid             string `param:"txid,required"`
+  	IncludeWatchonly bool   `param:"include_watchonly"`
+  }
+
+  //gettransaction returns the bundle as a tx. Txs of watch-only accounts are included only if include_watchonly is true,
+  //and the bundle is not found if it has no other txs of the wallet.
   func gettransaction(conf *Conf, req *Request, res *Response) error {
   	mutex.RLock()
   	defer mutex.RUnlock()
-  	data, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("invalid params")
-  	}
-  	bundlestr := ""
-  	switch len(data) {
-  	case 2:
-  	case 1:
-  		bundlestr, ok = data[0].(string)
-  		if !ok {
-  			return errors.New("invalid txid")
-  		}
-  	default:
-  		return errors.New("invalid params")
+  	var p gettransactionParams
+  	if err := defaultIncludeWatchonly(&p.IncludeWatchonly); err != nil {
+  		return err
   	}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	bundlestr := p.Txid
 
   	var amount int64
   	nconf := 0
   	var dt *transaction
   	var detailss []*details
   	bundle := gadk.Trytes(bundlestr)
-  	var allConfirmed bool = true
 
-    // check inclusion state (live mesh lookup)
-    var hashes_to_check []gadk.Trytes
-
-  	err_check := db.View(func(tx *bolt.Tx) error {
+  	err := db.View(func(tx *bolt.Tx) error {
   		trs, hs, err := findTX(tx, bundle)
+  		if err == errTxNotFound {
+  			return errTxidNotFound
+  		}
   		if err != nil {
   			return err
   		}
-  		hashes_to_check = make([]gadk.Trytes, 0, len(hs))
   		if len(trs) == 0 {
-  			return errors.New("bundle not found")
+  			return errTxidNotFound
   		}
-      for i_check, tr_check_confirmed := range hs {
-  			// if unconfirmed, trigger a live mesh lookup
-  			if !hs[i_check].Confirmed {
-  				allConfirmed = false; // have to trigger mesh lookup
-  				hashes_to_check = append(hashes_to_check, tr_check_confirmed.Hash)
+  		// if unconfirmed, trigger a live mesh lookup
+  		var hashesToCheck []gadk.Trytes
+  		for _, h := range hs {
+  			if !h.Confirmed {
+  				hashesToCheck = append(hashesToCheck, h.Hash)
   			}
   		}
-  		return nil
-  	})
-  	if err_check != nil {
-  		return err_check
-  	}
-
-  	err := db.View(func(tx *bolt.Tx) error {
-  		trs, hs, err := findTX(tx, bundle)
-  		if err != nil {
-  			return err
+  		//txs in the bundle can be reattached, and the bundle is confirmed if one of them is confirmed.
+  		var confirmed bool
+  		for _, h := range hs {
+  			confirmed = confirmed || h.Confirmed
   		}
-  		if len(trs) == 0 {
-  			return errors.New("bundle not found")
-  		}
-  		detailss = make([]*details, 0, len(trs))
-  		indice := make(map[int64]struct{})
-
-  		// found some unconfirmed, so perform mesh lookup and DB Update
-  		if !allConfirmed {
-  			//get newly added and newly confirmed trytes direclty from mesh
-  			ni, errNode := conf.api.GetNodeInfo()
-  			if errNode != nil {
-  				return nil
+  		if len(hashesToCheck) > 0 {
+  			ni, err := conf.api.GetNodeInfo()
+  			if err != nil {
+  				return err
   			}
-  			inc, err := conf.api.GetInclusionStates(hashes_to_check, []gadk.Trytes{ni.LatestMilestone})
+  			inc, err := conf.api.GetInclusionStates(hashesToCheck, []gadk.Trytes{ni.LatestMilestone})
   			if err != nil {
   				return err
   			}
-  			if len(inc.States) > 0 {
-  				for i, i_included := range inc.States {
-  					if i_included {
-  							hs[i].Confirmed = true
-  					}
-  				}
+  			for _, included := range inc.States {
+  				confirmed = confirmed || included
   			}
   		}
 
-  		for i, tr := range trs {
-  			dt2, errr := getTransaction(tx, conf, tr, hs[i].Confirmed)
+  		detailss = make([]*details, 0, len(trs))
+  		indice := make(map[int64]struct{})
+  		for _, tr := range trs {
+  			ac, _, errr := findAddress(tx, tr.Address)
+  			if errr != nil {
+  				return errr
+  			}
+  			if ac != nil && ac.WatchOnly && !p.IncludeWatchonly {
+  				continue
+  			}
+  			dt2, errr := getTransaction(tx, conf, tr, confirmed)
   			if errr != nil {
   				return errr
   			}
@@ -445,13 +459,16 @@
   			amount += tr.Value
   			detailss = append(detailss, d)
   		}
+  		if dt == nil {
+  			return errTxidNotFound
+  		}
   		return nil
   	})
   	if err != nil {
   		return err
   	}
   	res.Result = &tx{
-  		Amount:            float64(amount) / 100000000,
+  		Amount:            Amount(amount),
   		Confirmations:     nconf,
   		Blocktime:         dt.Blocktime,
   		Blockhash:         dt.Blockhash,
@@ -470,10 +487,10 @@
   	Account  *string     `json:"account"`
   	Address  gadk.Trytes `json:"address"`
   	Category string      `json:"category"`
-  	Amount   float64     `json:"amount"`
+  	Amount   Amount      `json:"amount"`
   	// Label             string      `json:"label"`
   	Vout          int64   `json:"vout"`
-  	Fee           float64 `json:"fee"`
+  	Fee           Amount  `json:"fee"`
   	Confirmations int     `json:"confirmations"`
   	Trusted       *bool   `json:"trusted,omitempty"`
   	// Generated         bool        `json:"generated"`
@@ -491,88 +508,202 @@
   	Abandoned         *bool  `json:"abandoned,omitempty"`
   }
 
+  type listtransactionsParams struct {
+  	Account          string `param:"account|label|dummy"`
+  	Count            int    `param:"count"`
+  	Skip             int    `param:"skip|from"`
+  	IncludeWatchonly bool   `param:"include_watchonly"`
+  }
+
   //do not support over 1000 txs.
   func listtransactions(conf *Conf, req *Request, res *Response) error {
   	mutex.RLock()
   	defer mutex.RUnlock()
-  	data, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("invalid params")
-  	}
-  	acc := "*"
-  	num := 10
-  	skip := 0
-  	switch len(data) {
-  	case 4:
-  		fallthrough
-  	case 3:
-  		n, okk := data[2].(float64)
-  		if !okk {
-  			return errors.New("invalid number")
+  	p := listtransactionsParams{
+  		Account: "*",
+  		Count:   10,
+  	}
+  	if err := defaultIncludeWatchonly(&p.IncludeWatchonly); err != nil {
+  		return err
+  	}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	if p.Count < 0 || p.Skip < 0 {
+  		return newErr(RPCInvalidParameter, "negative count or skip")
+  	}
+  	acc := p.Account
+  	num := p.Count
+  	skip := p.Skip
+  	var ltx []*transaction
+  	err := db.View(func(tx *bolt.Tx) error {
+  		skipped := 0
+  		return walkHashesReverse(tx, func(target *txstate) (bool, error) {
+  			if len(ltx) >= num {
+  				return false, nil
+  			}
+  			tr, err := getTX(tx, target.Hash)
+  			if err != nil {
+  				return true, nil
+  			}
+  			ac, _, err := findAddress(tx, tr.Address)
+  			if err != nil {
+  				return false, err
+  			}
+  			if ac != nil && ac.WatchOnly && !p.IncludeWatchonly {
+  				return true, nil
+  			}
+  			//for replay bundles(i.e. multiple bundles with a same hash)
+  			inc, err := isConfirmed(tx, target, tr)
+  			if err != nil {
+  				return false, err
+  			}
+  			dt, err := getTransaction(tx, conf, tr, inc)
+  			if err != nil {
+  				return false, err
+  			}
+  			if acc != "*" && *dt.Account != acc {
+  				return true, nil
+  			}
+  			if skipped++; skipped > skip {
+  				ltx = append(ltx, dt)
+  			}
+  			return true, nil
+  		})
+  	})
+  	res.Result = ltx
+  	return err
+  }
+
+  //isConfirmed returns true if the tx or one of txs in its bundle (i.e. replays) is confirmed.
+  func isConfirmed(tx *bolt.Tx, target *txstate, tr *gadk.Transaction) (bool, error) {
+  	if target.Confirmed {
+  		return true, nil
+  	}
+  	for _, hash := range hashesByBundle(tx, tr.Bundle) {
+  		h, err := getHash(tx, hash)
+  		if err != nil {
+  			return false, err
+  		}
+  		if h != nil && h.Confirmed {
+  			return true, nil
   		}
-  		skip = int(n)
-  		fallthrough
-  	case 2:
-  		n, okk := data[1].(float64)
-  		if !okk {
-  			return errors.New("invalid number")
+  	}
+  	return false, nil
+  }
+
+  type listsinceblockParams struct {
+  	Blockhash           string `param:"blockhash"`
+  	TargetConfirmations int    `param:"target_confirmations"`
+  	IncludeWatchonly    bool   `param:"include_watchonly"`
+  	IncludeRemoved      bool   `param:"include_removed"`
+  }
+
+  type sinceblock struct {
+  	Transactions []*transaction `json:"transactions"`
+  	Lastblock    string         `json:"lastblock"`
+  }
+
+  //isWatchOnlyWallet returns true if all accounts in the wallet are watch-only.
+  func isWatchOnlyWallet(tx *bolt.Tx) (bool, error) {
+  	acs, err := listAccount(tx)
+  	if err != nil {
+  		return false, err
+  	}
+  	for _, ac := range acs {
+  		if !ac.WatchOnly {
+  			return false, nil
   		}
-  		num = int(n)
-  		fallthrough
-  	case 1:
-  		acc, ok = data[0].(string)
-  		if !ok {
-  			return errors.New("invalid account")
+  	}
+  	return len(acs) > 0, nil
+  }
+
+  //defaultIncludeWatchonly sets the default of include_watchonly, which is true only in a watch-only wallet
+  //as in bitcoind.
+  func defaultIncludeWatchonly(include *bool) error {
+  	return db.View(func(tx *bolt.Tx) error {
+  		var err error
+  		*include, err = isWatchOnlyWallet(tx)
+  		return err
+  	})
+  }
+
+  //listsinceblock uses a sequence number in the hashes DB as a block hash.
+  //It returns txs which are added or confirmed after the sequence.
+  //Txs of watch-only accounts are included only if include_watchonly is true.
+  //A tx is returned again when it is confirmed, so lastblock is always the last sequence
+  //regardless of target_confirmations.
+  func listsinceblock(conf *Conf, req *Request, res *Response) error {
+  	mutex.RLock()
+  	defer mutex.RUnlock()
+  	p := listsinceblockParams{
+  		TargetConfirmations: 1,
+  		IncludeRemoved:      true,
+  	}
+  	if err := defaultIncludeWatchonly(&p.IncludeWatchonly); err != nil {
+  		return err
+  	}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	if p.TargetConfirmations < 1 {
+  		return newErr(RPCInvalidParameter, "Invalid parameter")
+  	}
+  	var since uint64
+  	if p.Blockhash != "" {
+  		var err error
+  		since, err = strconv.ParseUint(p.Blockhash, 10, 64)
+  		if err != nil {
+  			return newErr(RPCInvalidAddressOrKey, "Block not found")
   		}
-  	case 0:
-  	default:
-  		return errors.New("invalid params")
   	}
-  	var ltx []*transaction
+  	result := &sinceblock{
+  		Transactions: []*transaction{},
+  	}
   	err := db.View(func(tx *bolt.Tx) error {
-  		hs, err := getHashes(tx)
+  		last := lastSeq(tx)
+  		if since > last {
+  			return newErr(RPCInvalidAddressOrKey, "Block not found")
+  		}
+  		result.Lastblock = strconv.FormatUint(last, 10)
+  		hs, err := hashesSince(tx, since, true)
   		if err != nil {
   			return err
   		}
-  		if len(hs) == 0 {
-  			return nil
-  		}
-  		for skipped, i := 0, 0; i < len(hs) && len(ltx) < num; i++ {
-  			//for replay bundles(i.e. multiple bundles with a same hash)
-  			target := hs[len(hs)-1-i]
-  			tr, err := getTX(tx, target.Hash)
+  		for _, h := range hs {
+  			tr, err := getTX(tx, h.Hash)
   			if err != nil {
   				continue
   			}
-  			inc := target.Confirmed
-  			if !inc {
-  				_, hs, err2 := findTX(tx, tr.Bundle)
-  				if err2 != nil {
-  					return err2
-  				}
-  				for _, h := range hs {
-  					if h.Confirmed {
-  						inc = true
-  					}
-  				}
-  			}
-  			dt, err := getTransaction(tx, conf, tr, inc)
+  			ac, _, err := findAddress(tx, tr.Address)
   			if err != nil {
   				return err
   			}
-  			if acc != "*" && *dt.Account != acc {
+  			if ac != nil && ac.WatchOnly && !p.IncludeWatchonly {
   				continue
   			}
-  			if skipped++; skipped > skip {
-  				ltx = append(ltx, dt)
+  			inc, err := isConfirmed(tx, h, tr)
+  			if err != nil {
+  				return err
   			}
+  			dt, err := getTransaction(tx, conf, tr, inc)
+  			if err != nil {
+  				return err
+  			}
+  			result.Transactions = append(result.Transactions, dt)
   		}
   		return nil
   	})
-  	res.Result = ltx
-  	return err
+  	if err != nil {
+  		return err
+  	}
+  	res.Result = result
+  	return nil
   }
 
+  //confirmedConfirmations is the number of confirmations of confirmed txs.
+  const confirmedConfirmations = 100000
+
   func getTransaction(tx *bolt.Tx, conf *Conf, tr *gadk.Transaction, inc bool) (*transaction, error) {
   	ac, _, errr := findAddress(tx, tr.Address)
   	if errr != nil {
@@ -584,7 +715,7 @@
   	dt := &transaction{
   		Address:           tr.Address.WithChecksum(),
   		Category:          "send",
-  		Amount:            float64(tr.Value) / 100000000,
+  		Amount:            Amount(tr.Value),
   		Txid:              tr.Bundle,
   		Walletconflicts:   []string{},
   		Time:              tr.Timestamp.Unix(),
@@ -599,7 +730,7 @@
   		dt.Blockhash = &emp
   		dt.Blocktime = &dt.Time
   		dt.Blockindex = &zero
-  		dt.Confirmations = 100000
+  		dt.Confirmations = confirmedConfirmations
   	} else {
   		dt.Trusted = &f
   	}
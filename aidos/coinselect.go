@@ -0,0 +1,191 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+
+  import (
+  	"sort"
+  )
+
+  //Coin selection policies, which choose balances spent as inputs of a bundle.
+  const (
+  	oldestFirst       = "oldest-first" //in order of address index (default)
+  	largestFirst      = "largest-first"
+  	minimalInputs     = "minimal-inputs"
+  	exactMatch        = "exact-match" //without change
+  	dustConsolidation = "dust-consolidation"
+  )
+
+  const (
+  	//dustLimit is the max balance regarded as dust by dust-consolidation (0.01 ADK).
+  	dustLimit = 1000000
+  	//maxDustInputs is the max number of dust balances spent by dust-consolidation.
+  	maxDustInputs = 10
+  	//maxExactSearch is the max number of steps to search inputs by exact-match.
+  	maxExactSearch = 100000
+  )
+
+  var errNoExactMatch = newErr(RPCWalletInsufficientFunds, "no inputs match the amount exactly")
+
+  func isCoinSelection(policy string) bool {
+  	switch policy {
+  	case oldestFirst, largestFirst, minimalInputs, exactMatch, dustConsolidation:
+  		return true
+  	}
+  	return false
+  }
+
+  //coinSelection returns policy, or the one in aidosd.conf if policy is empty.
+  func (c *Conf) coinSelection(policy string) (string, error) {
+  	if policy == "" {
+  		policy = c.CoinSelection
+  	}
+  	if policy == "" {
+  		return oldestFirst, nil
+  	}
+  	if !isCoinSelection(policy) {
+  		return "", newErr(RPCInvalidParameter, "unknown coin selection "+policy)
+  	}
+  	return policy, nil
+  }
+
+  //selectCoins returns indice of bals spent to send total in order of spending,
+  //or nil if bals are insufficient.
+  func selectCoins(policy string, bals []Balance, total int64) ([]int, error) {
+  	var idx []int
+  	for i, b := range bals {
+  		if b.Value > 0 {
+  			idx = append(idx, i)
+  		}
+  	}
+  	largest := func() {
+  		sort.SliceStable(idx, func(i, j int) bool {
+  			return bals[idx[i]].Value > bals[idx[j]].Value
+  		})
+  	}
+  	switch policy {
+  	case "", oldestFirst:
+  		return takeCoins(bals, idx, total), nil
+  	case largestFirst:
+  		largest()
+  		return takeCoins(bals, idx, total), nil
+  	case minimalInputs:
+  		//the smallest balance which covers total, or the largest ones.
+  		best := -1
+  		for _, i := range idx {
+  			if bals[i].Value >= total && (best < 0 || bals[i].Value < bals[best].Value) {
+  				best = i
+  			}
+  		}
+  		if best >= 0 {
+  			return []int{best}, nil
+  		}
+  		largest()
+  		return takeCoins(bals, idx, total), nil
+  	case exactMatch:
+  		return exactCoins(bals, idx, total)
+  	case dustConsolidation:
+  		return dustCoins(bals, idx, total), nil
+  	}
+  	return nil, newErr(RPCInvalidParameter, "unknown coin selection "+policy)
+  }
+
+  //takeCoins takes bals[idx] in order until they cover total.
+  func takeCoins(bals []Balance, idx []int, total int64) []int {
+  	var sum int64
+  	for n, i := range idx {
+  		if sum += bals[i].Value; sum >= total {
+  			return idx[:n+1]
+  		}
+  	}
+  	return nil
+  }
+
+  //exactCoins searches bals[idx] whose sum is total by depth first search from larger ones.
+  func exactCoins(bals []Balance, idx []int, total int64) ([]int, error) {
+  	sort.SliceStable(idx, func(i, j int) bool {
+  		return bals[idx[i]].Value > bals[idx[j]].Value
+  	})
+  	//rest[n] is the sum of bals[idx[n:]].
+  	rest := make([]int64, len(idx)+1)
+  	for n := len(idx) - 1; n >= 0; n-- {
+  		rest[n] = rest[n+1] + bals[idx[n]].Value
+  	}
+  	if rest[0] < total {
+  		return nil, nil
+  	}
+  	var selected []int
+  	steps := 0
+  	var search func(n int, remain int64) bool
+  	search = func(n int, remain int64) bool {
+  		if remain == 0 {
+  			return true
+  		}
+  		if steps++; n == len(idx) || rest[n] < remain || steps > maxExactSearch {
+  			return false
+  		}
+  		if v := bals[idx[n]].Value; v <= remain {
+  			selected = append(selected, idx[n])
+  			if search(n+1, remain-v) {
+  				return true
+  			}
+  			selected = selected[:len(selected)-1]
+  		}
+  		return search(n+1, remain)
+  	}
+  	if !search(0, total) {
+  		return nil, errNoExactMatch
+  	}
+  	sort.Ints(selected)
+  	return selected, nil
+  }
+
+  //dustCoins spends up to maxDustInputs smallest dust balances, and then others in order of address index
+  //if the dust is insufficient.
+  func dustCoins(bals []Balance, idx []int, total int64) []int {
+  	var dust, others []int
+  	for _, i := range idx {
+  		if bals[i].Value < dustLimit {
+  			dust = append(dust, i)
+  		} else {
+  			others = append(others, i)
+  		}
+  	}
+  	sort.SliceStable(dust, func(i, j int) bool {
+  		return bals[dust[i]].Value < bals[dust[j]].Value
+  	})
+  	if len(dust) > maxDustInputs {
+  		others = append(others, dust[maxDustInputs:]...)
+  		sort.Ints(others)
+  		dust = dust[:maxDustInputs]
+  	}
+  	var sum int64
+  	for _, i := range dust {
+  		sum += bals[i].Value
+  	}
+  	if sum >= total {
+  		return dust
+  	}
+  	rest := takeCoins(bals, others, total-sum)
+  	if rest == nil {
+  		return nil
+  	}
+  	return append(dust, rest...)
+  }
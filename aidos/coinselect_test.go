@@ -0,0 +1,179 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+
+  import (
+  	"reflect"
+  	"testing"
+
+  	"github.com/AidosKuneen/gadk"
+  )
+
+  func coinAccount(values ...int64) *Account {
+  	ac := &Account{Name: "coin"}
+  	for i, v := range values {
+  		adr := gadk.Address(string(rune('A'+i))) + gadk.EmptyAddress[1:]
+  		ac.Balances = append(ac.Balances, Balance{
+  			Balance: gadk.Balance{Address: adr, Value: v},
+  		})
+  	}
+  	return ac
+  }
+
+  func TestSelectCoins(t *testing.T) {
+  	dust := make([]int64, 12)
+  	for i := range dust {
+  		dust[i] = int64(100 + i)
+  	}
+  	tests := []struct {
+  		name   string
+  		policy string
+  		ac     *Account
+  		total  int64
+  		idx    []int
+  		err    error
+  	}{
+  		{"oldest", oldestFirst, coinAccount(30, 0, 50, 100), 60, []int{0, 2}, nil},
+  		{"oldest default", "", coinAccount(30, 0, 50, 100), 60, []int{0, 2}, nil},
+  		{"oldest insufficient", oldestFirst, coinAccount(30, 50), 81, nil, nil},
+  		{"largest", largestFirst, coinAccount(30, 50, 100, 20), 120, []int{2, 1}, nil},
+  		{"largest tie", largestFirst, coinAccount(50, 100, 100), 150, []int{1, 2}, nil},
+  		{"largest insufficient", largestFirst, coinAccount(30, 50), 81, nil, nil},
+  		{"minimal single", minimalInputs, coinAccount(30, 200, 70, 100), 60, []int{2}, nil},
+  		{"minimal multiple", minimalInputs, coinAccount(30, 50, 40, 10), 85, []int{1, 2}, nil},
+  		{"minimal insufficient", minimalInputs, coinAccount(30, 50), 81, nil, nil},
+  		{"exact single", exactMatch, coinAccount(30, 70, 100), 70, []int{1}, nil},
+  		{"exact multiple", exactMatch, coinAccount(30, 70, 45, 25, 100), 55, []int{0, 3}, nil},
+  		{"exact all", exactMatch, coinAccount(30, 70, 45), 145, []int{0, 1, 2}, nil},
+  		{"exact no match", exactMatch, coinAccount(30, 70, 100), 60, nil, errNoExactMatch},
+  		{"exact insufficient", exactMatch, coinAccount(30, 70), 101, nil, nil},
+  		{"dust only", dustConsolidation, coinAccount(5*dustLimit, 100, 2*dustLimit, 200), 250, []int{1, 3}, nil},
+  		{"dust and others", dustConsolidation, coinAccount(5*dustLimit, 100, 2*dustLimit, 200), dustLimit, []int{1, 3, 0}, nil},
+  		{"dust limited", dustConsolidation, coinAccount(dust...), 100, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, nil},
+  		{"dust overflow", dustConsolidation, coinAccount(dust...), 1200, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}, nil},
+  		{"dust insufficient", dustConsolidation, coinAccount(100, dustLimit), dustLimit + 101, nil, nil},
+  		{"unknown", "random", coinAccount(100), 10, nil, nil},
+  	}
+  	for _, tt := range tests {
+  		idx, err := selectCoins(tt.policy, tt.ac.Balances, tt.total)
+  		if tt.name == "unknown" {
+  			if err == nil {
+  				t.Error(tt.name, "should be error")
+  			}
+  			continue
+  		}
+  		if err != tt.err {
+  			t.Error(tt.name, "invalid error", err)
+  		}
+  		if !reflect.DeepEqual(idx, tt.idx) {
+  			t.Error(tt.name, "invalid inputs", idx, tt.idx)
+  		}
+  	}
+  }
+
+  func TestAddRemainder(t *testing.T) {
+  	change := gadk.Address("Z") + gadk.EmptyAddress[1:]
+  	tests := []struct {
+  		policy string
+  		values []int64
+  		change int64
+  	}{
+  		{oldestFirst, []int64{0, 0, 100}, 0},
+  		{largestFirst, []int64{30, 50, 0}, 20},
+  		{minimalInputs, []int64{30, 50, 0}, 20},
+  		{exactMatch, []int64{0, 0, 100}, 0},
+  	}
+  	for _, tt := range tests {
+  		ac := coinAccount(30, 50, 100)
+  		var bd gadk.Bundle
+  		ok, err := addRemainder(nil, &bd, ac, func() (gadk.Address, error) {
+  			return change, nil
+  		}, 80, tt.policy)
+  		if err != nil || !ok {
+  			t.Fatal(tt.policy, ok, err)
+  		}
+  		for i, v := range tt.values {
+  			if ac.Balances[i].Value != v {
+  				t.Error(tt.policy, "invalid balance", i, ac.Balances[i].Value)
+  			}
+  		}
+  		var c int64
+  		if i := ac.search(change); i >= 0 {
+  			c = ac.Balances[i].Change
+  		}
+  		if c != tt.change {
+  			t.Error(tt.policy, "invalid change", c)
+  		}
+  	}
+  }
+
+  func TestChangeCollision(t *testing.T) {
+  	prepareTest(t)
+  	seed := gadk.NewSeed()
+  	//an address at the index of a new change address, which is put at a wrong position.
+  	adr, err := gadk.NewAddress(seed, 1, 2)
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	ac := newAccount("ac1", seed)
+  	ac.Balances = []Balance{
+  		{Balance: gadk.Balance{Address: adr, Value: 100}},
+  	}
+  	trs := []gadk.Transfer{
+  		{Address: gadk.Address("A") + gadk.EmptyAddress[1:], Value: 30},
+  	}
+  	if _, err = fundTransfers(nil, ac, trs, "", oldestFirst); err != errChangeCollision {
+  		t.Error("colliding change address should be error", err)
+  	}
+  }
+
+  func TestPendingChange(t *testing.T) {
+  	change := gadk.Address("Z") + gadk.EmptyAddress[1:]
+  	ac := coinAccount(30, 0, 10)
+  	ac.Balances[1].Change = 50
+  	ac.Balances[2].Change = 5
+  	spend := func() error {
+  		var bd gadk.Bundle
+  		ok, err := addRemainder(nil, &bd, ac, func() (gadk.Address, error) {
+  			return change, nil
+  		}, 45, largestFirst)
+  		if err == nil && !ok {
+  			err = errInsufficientBalance
+  		}
+  		return err
+  	}
+  	if err := spend(); err != errInsufficientBalance {
+  		t.Error("pending changes must not be spent", err)
+  	}
+  	if err := ac.withPendingChange(spend); err != nil {
+  		t.Fatal(err)
+  	}
+  	for i, v := range []int64{30, -50, 10, 0} {
+  		if ac.Balances[i].Value != v {
+  			t.Error("invalid balance", i, ac.Balances[i].Value)
+  		}
+  	}
+  	for i, c := range []int64{0, 50, 5, 5} {
+  		if ac.Balances[i].Change != c {
+  			t.Error("invalid change", i, ac.Balances[i].Change)
+  		}
+  	}
+  }
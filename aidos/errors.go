@@ -0,0 +1,90 @@
+  // Copyright (c) 2017 Aidos Developer
+
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+
+  package aidos
+
+  import "net/http"
+
+  //Error codes for JSON-RPC responses, same as bitcoind.
+  const (
+  	RPCMiscError                 = -1
+  	RPCTypeError                 = -3
+  	RPCWalletError               = -4
+  	RPCInvalidAddressOrKey       = -5
+  	RPCWalletInsufficientFunds   = -6
+  	RPCInvalidParameter          = -8
+  	RPCWalletInvalidAccountName  = -11
+  	RPCWalletUnlockNeeded        = -13
+  	RPCWalletPassphraseIncorrect = -14
+  	RPCWalletWrongEncState       = -15
+  	RPCDeserializationError      = -22
+  	RPCVerifyError               = -25
+  	RPCInvalidRequest            = -32600
+  	RPCMethodNotFound            = -32601
+  	RPCInvalidParams             = -32602
+  	RPCInternalError             = -32603
+  	RPCParseError                = -32700
+  )
+
+  func newErr(code int64, msg string) *Err {
+  	return &Err{
+  		Code:    code,
+  		Message: msg,
+  	}
+  }
+
+  //Error returns the message of e, so that Err can be returned as error from handlers.
+  func (e *Err) Error() string {
+  	return e.Message
+  }
+
+  //toErr converts err to Err. Errors which don't have a code are treated as RPCMiscError.
+  func toErr(err error) *Err {
+  	if e, ok := err.(*Err); ok {
+  		return e
+  	}
+  	return newErr(RPCMiscError, err.Error())
+  }
+
+  //httpStatus returns HTTP status code for a response of non-batch request
+  //with error e, in the same way as bitcoind.
+  func httpStatus(e *Err) int {
+  	if e == nil {
+  		return http.StatusOK
+  	}
+  	switch e.Code {
+  	case RPCInvalidRequest:
+  		return http.StatusBadRequest
+  	case RPCMethodNotFound:
+  		return http.StatusNotFound
+  	default:
+  		return http.StatusInternalServerError
+  	}
+  }
+
+  var (
+  	errNotPrivileged       = newErr(RPCWalletUnlockNeeded, "not priviledged")
+  	errInsufficientBalance = newErr(RPCWalletInsufficientFunds, "insufficient balance")
+  	errTxidNotFound        = newErr(RPCInvalidAddressOrKey, "bundle not found")
+  	errWatchOnly           = newErr(RPCWalletError, "watch-only account doesn't have a seed")
+  	errBundleTooLarge      = newErr(RPCWalletError, "too many inputs for max_bundle_size")
+  	errChangeCollision     = newErr(RPCWalletError, "change address is already in the account")
+  	errMalformedInput      = newErr(RPCDeserializationError, "input is not followed by its 2nd signature fragment")
+  )
@@ -21,59 +21,245 @@
   package aidos
   
   import (
+  	"bytes"
+  	"encoding/binary"
   	"encoding/json"
   	"errors"
   	"log"
-  
+
   	"github.com/AidosKuneen/gadk"
   	"github.com/boltdb-go/bolt"
   )
-  
-  var hashDB = []byte("hashes")
-  
+
+  var (
+  	hashDB    = []byte("hashes")         // tx hash -> txstate
+  	seqDB     = []byte("hash_sequences") // sequence number -> tx hash
+  	bundleDB  = []byte("bundle_hashes")  // bundle hash + tx hash -> nil
+  	addressDB = []byte("address_hashes") // address + tx hash -> nil
+  )
+
+  //txstate is a state of tx in the wallet.
+  //Seq is a sequence number when the tx is added to the DB, and ConfSeq is one
+  //when the tx is confirmed. Both are assigned by putHash.
   type txstate struct {
   	Hash      gadk.Trytes
   	Confirmed bool
+  	Seq       uint64 `json:",omitempty"`
+  	ConfSeq   uint64 `json:",omitempty"`
   }
-  
+
+  func seqKey(seq uint64) []byte {
+  	key := make([]byte, 8)
+  	binary.BigEndian.PutUint64(key, seq)
+  	return key
+  }
+
+  func getHash(tx *bolt.Tx, hash gadk.Trytes) (*txstate, error) {
+  	b := tx.Bucket(hashDB)
+  	if b == nil {
+  		return nil, nil
+  	}
+  	v := b.Get([]byte(hash))
+  	if v == nil {
+  		return nil, nil
+  	}
+  	var h txstate
+  	if err := json.Unmarshal(v, &h); err != nil {
+  		return nil, err
+  	}
+  	return &h, nil
+  }
+
+  //putHash stores h, with assigning sequence numbers to h if not assigned.
+  func putHash(tx *bolt.Tx, h *txstate) error {
+  	b, err := tx.CreateBucketIfNotExists(hashDB)
+  	if err != nil {
+  		return err
+  	}
+  	bs, err := tx.CreateBucketIfNotExists(seqDB)
+  	if err != nil {
+  		return err
+  	}
+  	if h.Seq == 0 {
+  		if h.Seq, err = b.NextSequence(); err != nil {
+  			return err
+  		}
+  	}
+  	if b.Get([]byte(h.Hash)) == nil {
+  		if err = putHashCount(tx, uint64(countHashes(tx))+1); err != nil {
+  			return err
+  		}
+  	}
+  	if err = bs.Put(seqKey(h.Seq), []byte(h.Hash)); err != nil {
+  		return err
+  	}
+  	if h.Confirmed {
+  		if h.ConfSeq == 0 {
+  			if h.ConfSeq, err = b.NextSequence(); err != nil {
+  				return err
+  			}
+  		}
+  		if err = bs.Put(seqKey(h.ConfSeq), []byte(h.Hash)); err != nil {
+  			return err
+  		}
+  	}
+  	bin, err := json.Marshal(h)
+  	if err != nil {
+  		return err
+  	}
+  	return b.Put([]byte(h.Hash), bin)
+  }
+
+  //getHashes returns all txstates in the order of addition.
   func getHashes(tx *bolt.Tx) ([]*txstate, error) {
+  	return hashesSince(tx, 0, false)
+  }
+
+  //hashesSince returns txstates which are added after the sequence number since
+  //in the order of addition. If withConfirmed, txstates which are confirmed after since are also returned.
+  func hashesSince(tx *bolt.Tx, since uint64, withConfirmed bool) ([]*txstate, error) {
   	var hs []*txstate
-  	b := tx.Bucket(hashDB)
+  	b := tx.Bucket(seqDB)
   	if b == nil {
   		return nil, nil
   	}
-  	v := b.Get(hashDB)
-  	if v != nil {
-  		if err := json.Unmarshal(v, &hs); err != nil {
+  	exist := make(map[gadk.Trytes]struct{})
+  	c := b.Cursor()
+  	for k, v := c.Seek(seqKey(since + 1)); k != nil; k, v = c.Next() {
+  		if _, ok := exist[gadk.Trytes(v)]; ok {
+  			continue
+  		}
+  		h, err := getHash(tx, gadk.Trytes(v))
+  		if err != nil {
   			return nil, err
   		}
+  		if h == nil {
+  			continue
+  		}
+  		if !withConfirmed && binary.BigEndian.Uint64(k) != h.Seq {
+  			continue
+  		}
+  		exist[h.Hash] = struct{}{}
+  		hs = append(hs, h)
   	}
   	return hs, nil
   }
+
+  //walkHashesReverse calls f with txstates in the reverse order of addition
+  //until f returns false or an error.
+  func walkHashesReverse(tx *bolt.Tx, f func(*txstate) (bool, error)) error {
+  	b := tx.Bucket(seqDB)
+  	if b == nil {
+  		return nil
+  	}
+  	c := b.Cursor()
+  	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+  		h, err := getHash(tx, gadk.Trytes(v))
+  		if err != nil {
+  			return err
+  		}
+  		//skip sequence numbers of confirmation
+  		if h == nil || h.Seq != binary.BigEndian.Uint64(k) {
+  			continue
+  		}
+  		next, err := f(h)
+  		if err != nil || !next {
+  			return err
+  		}
+  	}
+  	return nil
+  }
+
   func putHashes(tx *bolt.Tx, hs []*txstate) error {
-  	b, err := tx.CreateBucketIfNotExists(hashDB)
+  	for _, h := range hs {
+  		if err := putHash(tx, h); err != nil {
+  			return err
+  		}
+  	}
+  	return nil
+  }
+
+  var hashCountKey = []byte("hash_count") // key in metaDB for the number of txstates
+
+  //countHashes returns the number of txstates, which is counted by putHash.
+  func countHashes(tx *bolt.Tx) int {
+  	b := tx.Bucket(metaDB)
+  	if b == nil {
+  		return 0
+  	}
+  	v := b.Get(hashCountKey)
+  	if len(v) != 8 {
+  		return 0
+  	}
+  	return int(binary.BigEndian.Uint64(v))
+  }
+
+  func putHashCount(tx *bolt.Tx, n uint64) error {
+  	b, err := tx.CreateBucketIfNotExists(metaDB)
   	if err != nil {
   		return err
   	}
-  	bin, err := json.Marshal(hs)
+  	v := make([]byte, 8)
+  	binary.BigEndian.PutUint64(v, n)
+  	return b.Put(hashCountKey, v)
+  }
+
+  //buildHashCount counts txstates in the DB made by older versions.
+  func buildHashCount(tx *bolt.Tx) error {
+  	var n uint64
+  	err := walkHashesReverse(tx, func(*txstate) (bool, error) {
+  		n++
+  		return true, nil
+  	})
   	if err != nil {
   		return err
   	}
-  	return b.Put(hashDB, bin)
+  	return putHashCount(tx, n)
   }
-  
+
+  //resetHashes removes all txstates and indice of txs, which are rebuilt when txs are found again.
+  //Sequence numbers are kept so that they are never reused.
+  func resetHashes(tx *bolt.Tx) error {
+  	seq := lastSeq(tx)
+  	if err := putHashCount(tx, 0); err != nil {
+  		return err
+  	}
+  	for _, name := range [][]byte{hashDB, seqDB, bundleDB, addressDB} {
+  		if tx.Bucket(name) == nil {
+  			continue
+  		}
+  		if err := tx.DeleteBucket(name); err != nil {
+  			return err
+  		}
+  	}
+  	b, err := tx.CreateBucket(hashDB)
+  	if err != nil {
+  		return err
+  	}
+  	return b.SetSequence(seq)
+  }
+
+  //lastSeq returns the last sequence number assigned by putHash.
+  func lastSeq(tx *bolt.Tx) uint64 {
+  	b := tx.Bucket(hashDB)
+  	if b == nil {
+  		return 0
+  	}
+  	return b.Sequence()
+  }
+
   var txDB = []byte("transactions")
-  
+
   var errTxNotFound = errors.New("tx is not found")
-  
+
   var emptysig gadk.Trytes
-  
+
   func init() {
   	for i := 0; i < gadk.SignatureSize/3; i++ {
   		emptysig += "9"
   	}
   }
-  
+
   func getTX(tx *bolt.Tx, hash gadk.Trytes) (*gadk.Transaction, error) {
   	b := tx.Bucket(txDB)
   	if b == nil {
@@ -86,7 +272,7 @@
   	trytes := emptysig + gadk.Trytes(v)
   	return gadk.NewTransaction(trytes)
   }
-  
+
   func getTXs(tx *bolt.Tx, hash []gadk.Trytes) ([]*gadk.Transaction, error) {
   	b := tx.Bucket(txDB)
   	if b == nil {
@@ -107,57 +293,150 @@
   	}
   	return ret, nil
   }
+
   func putTX(tx *bolt.Tx, tr *gadk.Transaction) error {
   	b, err := tx.CreateBucketIfNotExists(txDB)
   	if err != nil {
   		return err
   	}
   	trytes := tr.Trytes()[gadk.SignatureSize/3:]
-  	return b.Put([]byte(tr.Hash()), []byte(trytes))
+  	hash := tr.Hash()
+  	if err := b.Put([]byte(hash), []byte(trytes)); err != nil {
+  		return err
+  	}
+  	return putTXIndex(tx, tr, hash)
   }
-  
-  func findTX(tx *bolt.Tx, bundle gadk.Trytes) ([]*gadk.Transaction, []*txstate, error) {
-  	b := tx.Bucket(txDB)
+
+  func putTXIndex(tx *bolt.Tx, tr *gadk.Transaction, hash gadk.Trytes) error {
+  	bb, err := tx.CreateBucketIfNotExists(bundleDB)
+  	if err != nil {
+  		return err
+  	}
+  	if err := bb.Put(append([]byte(tr.Bundle), hash...), []byte{}); err != nil {
+  		return err
+  	}
+  	ba, err := tx.CreateBucketIfNotExists(addressDB)
+  	if err != nil {
+  		return err
+  	}
+  	return ba.Put(append([]byte(tr.Address), hash...), []byte{})
+  }
+
+  func indexedHashes(tx *bolt.Tx, name []byte, prefix []byte) []gadk.Trytes {
+  	b := tx.Bucket(name)
   	if b == nil {
-  		return nil, nil, errTxNotFound
+  		return nil
   	}
+  	var hs []gadk.Trytes
   	c := b.Cursor()
-  	trmap := make(map[gadk.Trytes]*gadk.Transaction)
-  	for k, v := c.First(); k != nil; k, v = c.Next() {
-  		trytes := emptysig + gadk.Trytes(v)
-  		tr, err := gadk.NewTransaction(trytes)
+  	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+  		hs = append(hs, gadk.Trytes(k[len(prefix):]))
+  	}
+  	return hs
+  }
+
+  //hashesByBundle returns hashes of txs in the bundle.
+  func hashesByBundle(tx *bolt.Tx, bundle gadk.Trytes) []gadk.Trytes {
+  	return indexedHashes(tx, bundleDB, []byte(bundle))
+  }
+
+  //hashesByAddress returns hashes of txs whose address is adr.
+  func hashesByAddress(tx *bolt.Tx, adr gadk.Address) []gadk.Trytes {
+  	return indexedHashes(tx, addressDB, []byte(adr))
+  }
+
+  func findTX(tx *bolt.Tx, bundle gadk.Trytes) ([]*gadk.Transaction, []*txstate, error) {
+  	if tx.Bucket(txDB) == nil {
+  		return nil, nil, errTxNotFound
+  	}
+  	hashes := hashesByBundle(tx, bundle)
+  	trs, err := getTXs(tx, hashes)
+  	if err != nil {
+  		return nil, nil, err
+  	}
+  	hs := make([]*txstate, 0, len(hashes))
+  	for i, trh := range hashes {
+  		h, err := getHash(tx, trh)
   		if err != nil {
   			return nil, nil, err
   		}
-  		if tr.Bundle == bundle {
-  			trmap[gadk.Trytes(k)] = tr
+  		if h == nil {
+  			log.Println(trs[i].Bundle, trh, trs[i].Trytes())
+  			return nil, nil, errors.New("hash not found for " + string(trh))
   		}
+  		hs = append(hs, h)
   	}
-  	var hashes []*txstate
-  	hs, err := getHashes(tx)
-  	if err != nil {
-  		return nil, nil, err
+  	return trs, hs, nil
+  }
+
+  //migrateHashDB moves txstates in a JSON array under the "hashes" key to per-hash keys,
+  //and builds indice of txs.
+  func migrateHashDB(tx *bolt.Tx) error {
+  	b := tx.Bucket(hashDB)
+  	if b == nil {
+  		return nil
   	}
-  	for trh, tr := range trmap {
-  		var found bool
-  		for _, h := range hs {
-  			if h.Hash == trh {
-  				found = true
-  				hashes = append(hashes, h)
-  			}
+  	v := b.Get(hashDB)
+  	if v == nil {
+  		if needsTXIndex(tx) {
+  			return rebuildTXIndex(tx)
   		}
-  		if !found {
-  			log.Println(tr.Bundle, tr.Hash(), tr.Trytes())
-  			return nil, nil, errors.New("hash not found for " + string(tr.Hash()))
+  		return nil
+  	}
+  	log.Println("migrating hashes DB...")
+  	var hs []*txstate
+  	if err := json.Unmarshal(v, &hs); err != nil {
+  		return err
+  	}
+  	if err := b.Delete(hashDB); err != nil {
+  		return err
+  	}
+  	if err := putHashes(tx, hs); err != nil {
+  		return err
+  	}
+  	return rebuildTXIndex(tx)
+  }
+
+  //rebuildTXIndex rebuilds bundle and address indice from all txs in the DB.
+  func rebuildTXIndex(tx *bolt.Tx) error {
+  	for _, name := range [][]byte{bundleDB, addressDB} {
+  		if tx.Bucket(name) == nil {
+  			continue
+  		}
+  		if err := tx.DeleteBucket(name); err != nil {
+  			return err
   		}
   	}
-  	trs := make([]*gadk.Transaction, 0, len(trmap))
-  	for _, v := range trmap {
-  		trs = append(trs, v)
+  	bt := tx.Bucket(txDB)
+  	if bt == nil {
+  		return nil
   	}
-  	return trs, hashes, nil
+  	log.Println("rebuilding tx indice...")
+  	return bt.ForEach(func(k, v []byte) error {
+  		tr, err := gadk.NewTransaction(emptysig + gadk.Trytes(v))
+  		if err != nil {
+  			return err
+  		}
+  		return putTXIndex(tx, tr, gadk.Trytes(k))
+  	})
   }
-  
+
+  //RebuildIndex rebuilds indice of txs in the DB.
+  func RebuildIndex() error {
+  	return db.Update(rebuildTXIndex)
+  }
+
+  //needsTXIndex returns true if there are txs but no indice for them,
+  //e.g. the DB was made by older versions.
+  func needsTXIndex(tx *bolt.Tx) bool {
+  	bt := tx.Bucket(txDB)
+  	if bt == nil {
+  		return false
+  	}
+  	k, _ := bt.Cursor().First()
+  	return k != nil && tx.Bucket(bundleDB) == nil
+  }
+
   //UpdateTXs update TX db from hashes DB.
   func UpdateTXs(conf *Conf) error {
   	log.Println("Updating transactions in DB...")
@@ -0,0 +1,224 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+
+  package aidos
+
+  import (
+  	"encoding/json"
+  	"testing"
+  	"time"
+
+  	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
+  )
+
+  func TestHashDB(t *testing.T) {
+  	prepareTest(t)
+  	hs := []*txstate{
+  		{Hash: "A" + gadk.EmptyHash[1:]},
+  		{Hash: "B" + gadk.EmptyHash[1:], Confirmed: true},
+  		{Hash: "C" + gadk.EmptyHash[1:]},
+  	}
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		//old layout
+  		b, err := tx.CreateBucketIfNotExists(hashDB)
+  		if err != nil {
+  			return err
+  		}
+  		bin, err := json.Marshal(hs)
+  		if err != nil {
+  			return err
+  		}
+  		if err = b.Put(hashDB, bin); err != nil {
+  			return err
+  		}
+  		if err = migrateHashDB(tx); err != nil {
+  			return err
+  		}
+  		if b.Get(hashDB) != nil {
+  			t.Error("old key must be removed")
+  		}
+  		//must do nothing after migration
+  		return migrateHashDB(tx)
+  	})
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+
+  	err = db.Update(func(tx *bolt.Tx) error {
+  		hs2, err := getHashes(tx)
+  		if err != nil {
+  			return err
+  		}
+  		if len(hs2) != len(hs) {
+  			t.Fatal("invalid number of hashes", len(hs2))
+  		}
+  		for i, h := range hs2 {
+  			if h.Hash != hs[i].Hash || h.Confirmed != hs[i].Confirmed {
+  				t.Error("invalid hash", i, h)
+  			}
+  			if i > 0 && h.Seq <= hs2[i-1].Seq {
+  				t.Error("invalid order", i, h)
+  			}
+  		}
+  		if countHashes(tx) != len(hs) {
+  			t.Error("invalid count", countHashes(tx))
+  		}
+  		//DB without the count
+  		if err = putHashCount(tx, 0); err != nil {
+  			return err
+  		}
+  		if err = buildHashCount(tx); err != nil {
+  			return err
+  		}
+  		if countHashes(tx) != len(hs) {
+  			t.Error("invalid count after counting", countHashes(tx))
+  		}
+  		last := lastSeq(tx)
+  		if last != 4 {
+  			t.Error("invalid last sequence", last)
+  		}
+
+  		//confirm A
+  		a, err := getHash(tx, hs[0].Hash)
+  		if err != nil {
+  			return err
+  		}
+  		a.Confirmed = true
+  		if err = putHash(tx, a); err != nil {
+  			return err
+  		}
+  		if a.ConfSeq != last+1 {
+  			t.Error("invalid confirmed sequence", a.ConfSeq)
+  		}
+  		since, err := hashesSince(tx, last, false)
+  		if err != nil {
+  			return err
+  		}
+  		if len(since) != 0 {
+  			t.Error("confirmed tx must not be returned", len(since))
+  		}
+  		since, err = hashesSince(tx, last, true)
+  		if err != nil {
+  			return err
+  		}
+  		if len(since) != 1 || since[0].Hash != a.Hash {
+  			t.Error("confirmed tx must be returned", since)
+  		}
+  		since, err = hashesSince(tx, 2, true)
+  		if err != nil {
+  			return err
+  		}
+  		//B(confirmed), C(added) and A(confirmed)
+  		if len(since) != 3 {
+  			t.Error("invalid number of hashes", len(since))
+  		}
+  		if countHashes(tx) != len(hs) {
+  			t.Error("invalid count", countHashes(tx))
+  		}
+
+  		if err = resetHashes(tx); err != nil {
+  			return err
+  		}
+  		if countHashes(tx) != 0 {
+  			t.Error("hashes must be removed")
+  		}
+  		if tx.Bucket(bundleDB) != nil || tx.Bucket(addressDB) != nil {
+  			t.Error("indice of txs must be removed")
+  		}
+  		if lastSeq(tx) != last+1 {
+  			t.Error("sequence must be kept", lastSeq(tx))
+  		}
+  		return nil
+  	})
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  }
+
+  func toTrytes(n int) gadk.Trytes {
+  	const chars = "9ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+  	var t gadk.Trytes
+  	for ; n > 0; n /= len(chars) {
+  		t += gadk.Trytes(chars[n%len(chars)])
+  	}
+  	return t + gadk.EmptyHash[len(t):]
+  }
+
+  var benchBundles []gadk.Trytes
+
+  func prepareBenchTX(b *testing.B) {
+  	const (
+  		ntx     = 100000
+  		nbundle = 4 // txs per bundle
+  	)
+  	if benchBundles != nil {
+  		return
+  	}
+  	prepareTest(b)
+  	bundles := make([]gadk.Trytes, 0, ntx/nbundle)
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		for i := 0; i < ntx; i++ {
+  			if i%nbundle == 0 {
+  				bundles = append(bundles, toTrytes(i/nbundle+1))
+  			}
+  			tr := &gadk.Transaction{
+  				Address:      gadk.Address(toTrytes(i % 1000)),
+  				Value:        int64(i),
+  				Timestamp:    time.Unix(int64(i), 0),
+  				CurrentIndex: int64(i % nbundle),
+  				LastIndex:    nbundle - 1,
+  				Bundle:       bundles[len(bundles)-1],
+  			}
+  			if err := putTX(tx, tr); err != nil {
+  				return err
+  			}
+  			if err := putHash(tx, &txstate{Hash: tr.Hash()}); err != nil {
+  				return err
+  			}
+  		}
+  		return nil
+  	})
+  	if err != nil {
+  		b.Fatal(err)
+  	}
+  	benchBundles = bundles
+  }
+
+  func BenchmarkFindTX(b *testing.B) {
+  	prepareBenchTX(b)
+  	b.ResetTimer()
+  	for i := 0; i < b.N; i++ {
+  		err := db.View(func(tx *bolt.Tx) error {
+  			trs, _, err := findTX(tx, benchBundles[i%len(benchBundles)])
+  			if err != nil {
+  				return err
+  			}
+  			if len(trs) != 4 {
+  				b.Error("invalid number of txs", len(trs))
+  			}
+  			return nil
+  		})
+  		if err != nil {
+  			b.Fatal(err)
+  		}
+  	}
+  }
@@ -0,0 +1,158 @@
+  // Copyright (c) 2017 Aidos Developer
+
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+
+
+  package aidos
+
+  import (
+  	"time"
+
+  	"github.com/boltdb-go/bolt"
+  )
+
+  type walletinfo struct {
+  	WalletName         string  `json:"walletname"`
+  	WalletVersion      int     `json:"walletversion"`
+  	Balance            Amount  `json:"balance"`
+  	UnconfirmedBalance Amount  `json:"unconfirmed_balance"`
+  	ImmatureBalance    Amount  `json:"immature_balance"`
+  	TxCount            int     `json:"txcount"`
+  	KeypoolSize        int     `json:"keypoolsize"`
+  	UnlockedUntil      *int64  `json:"unlocked_until,omitempty"`
+  	PayTxFee           Amount  `json:"paytxfee"`
+  	LastReconcile      int64   `json:"lastreconcile"`
+  }
+
+  func getwalletinfo(conf *Conf, req *Request, res *Response) error {
+  	var p struct{}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	mutex.RLock()
+  	defer mutex.RUnlock()
+  	info := &walletinfo{
+  		WalletVersion: 1,
+  	}
+  	err := db.View(func(tx *bolt.Tx) error {
+  		acs, balmap, err := getBalance(tx)
+  		if err != nil {
+  			return err
+  		}
+  		var total, pending int64
+  		for _, ac := range acs {
+  			for _, b := range ac.Balances {
+  				total += balmap[b.Address].Confirmed
+  				pending += balmap[b.Address].Pending
+  			}
+  			info.KeypoolSize += len(ac.Balances)
+  		}
+  		info.Balance = Amount(total)
+  		info.UnconfirmedBalance = Amount(pending)
+  		info.LastReconcile = lastReconciledUnix(tx)
+  		info.TxCount = countHashes(tx)
+  		return nil
+  	})
+  	if err != nil {
+  		return err
+  	}
+  	//unlocked_until is omitted if the wallet is not encrypted, and 0 if locked.
+  	if conf.PassPhrase {
+  		var until int64
+  		if t := unlocker.unlockedUntil(); !t.IsZero() {
+  			until = t.Unix()
+  		}
+  		info.UnlockedUntil = &until
+  	}
+  	res.Result = info
+  	return nil
+  }
+
+  type blockchaininfo struct {
+  	Chain                string  `json:"chain"`
+  	Blocks               int64   `json:"blocks"`
+  	Headers              int64   `json:"headers"`
+  	BestBlockHash        string  `json:"bestblockhash"`
+  	MedianTime           int64   `json:"mediantime"`
+  	VerificationProgress float64 `json:"verificationprogress"`
+  	InitialBlockDownload bool    `json:"initialblockdownload"`
+  	Pruned               bool    `json:"pruned"`
+  	Warnings             string  `json:"warnings"`
+  }
+
+  //getblockchaininfo maps milestones to blocks, i.e. blocks is the latest solid milestone index,
+  //headers is the latest milestone index, and bestblockhash is the latest milestone.
+  func getblockchaininfo(conf *Conf, req *Request, res *Response) error {
+  	var p struct{}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	ni, err := conf.api.GetNodeInfo()
+  	if err != nil {
+  		return err
+  	}
+  	info := &blockchaininfo{
+  		Chain:         "main",
+  		Blocks:        ni.LatestSolidSubtangleMilestoneIndex,
+  		Headers:       ni.LatestMilestoneIndex,
+  		BestBlockHash: string(ni.LatestMilestone),
+  		MedianTime:    ni.Time / int64(time.Second/time.Millisecond),
+  	}
+  	if conf.Testnet {
+  		info.Chain = "test"
+  	}
+  	info.VerificationProgress = 1
+  	if info.Headers > 0 && info.Blocks < info.Headers {
+  		info.VerificationProgress = float64(info.Blocks) / float64(info.Headers)
+  		info.InitialBlockDownload = true
+  	}
+  	res.Result = info
+  	return nil
+  }
+
+  type networkinfo struct {
+  	Version         int     `json:"version"`
+  	Subversion      string  `json:"subversion"`
+  	ProtocolVersion int     `json:"protocolversion"`
+  	Connections     int64   `json:"connections"`
+  	RelayFee        Amount  `json:"relayfee"`
+  	Warnings        string  `json:"warnings"`
+  }
+
+  //getnetworkinfo returns versions of aidosd and the node in subversion,
+  //and the number of neighbors of the node as connections.
+  func getnetworkinfo(conf *Conf, req *Request, res *Response) error {
+  	var p struct{}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	ni, err := conf.api.GetNodeInfo()
+  	if err != nil {
+  		return err
+  	}
+  	version := conf.Version
+  	if version == "" {
+  		version = "unstable"
+  	}
+  	res.Result = &networkinfo{
+  		Subversion:  "/aidosd:" + version + "/" + ni.AppName + ":" + ni.AppVersion + "/",
+  		Connections: ni.Neighbors,
+  	}
+  	return nil
+  }
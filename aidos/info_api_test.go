@@ -0,0 +1,101 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+
+  package aidos
+
+  import (
+  	"testing"
+
+  	"github.com/AidosKuneen/gadk"
+  )
+
+  func TestInfo(t *testing.T) {
+  	conf, d1 := preparetSend(t)
+  	conf.api = d1
+  	if _, err := Walletnotify(conf); err != nil {
+  		t.Error(err)
+  	}
+
+  	var resp Response
+  	if err := getwalletinfo(conf, &Request{Method: "getwalletinfo"}, &resp); err != nil {
+  		t.Fatal(err)
+  	}
+  	wi, ok := resp.Result.(*walletinfo)
+  	if !ok {
+  		t.Fatal("result must be walletinfo")
+  	}
+  	var total, pending int64
+  	var n int
+  	for _, adrs := range d1.acc2adr {
+  		for _, a := range adrs {
+  			c, p := d1.balance(a)
+  			total += c
+  			pending += p
+  		}
+  		n += len(adrs)
+  	}
+  	if wi.Balance != Amount(total) {
+  		t.Error("invalid balance", wi.Balance, total)
+  	}
+  	if wi.UnconfirmedBalance != Amount(pending) {
+  		t.Error("invalid unconfirmed balance", wi.UnconfirmedBalance, pending)
+  	}
+  	if wi.KeypoolSize != n {
+  		t.Error("invalid keypoolsize", wi.KeypoolSize, n)
+  	}
+  	if wi.TxCount != len(d1.bundle)+n*5 {
+  		t.Error("invalid txcount", wi.TxCount)
+  	}
+  	if conf.PassPhrase && wi.UnlockedUntil == nil {
+  		t.Error("unlocked_until must be set")
+  	}
+
+  	resp = Response{}
+  	if err := getblockchaininfo(conf, &Request{Method: "getblockchaininfo"}, &resp); err != nil {
+  		t.Fatal(err)
+  	}
+  	bi, ok := resp.Result.(*blockchaininfo)
+  	if !ok {
+  		t.Fatal("result must be blockchaininfo")
+  	}
+  	if bi.Blocks != 100 || bi.Headers != 100 || gadk.Trytes(bi.BestBlockHash) != trunk {
+  		t.Error("invalid blockchaininfo", bi)
+  	}
+  	if bi.InitialBlockDownload || bi.VerificationProgress != 1 {
+  		t.Error("node should be synced", bi)
+  	}
+
+  	resp = Response{}
+  	if err := getnetworkinfo(conf, &Request{Method: "getnetworkinfo"}, &resp); err != nil {
+  		t.Fatal(err)
+  	}
+  	ni, ok := resp.Result.(*networkinfo)
+  	if !ok {
+  		t.Fatal("result must be networkinfo")
+  	}
+  	if ni.Connections != 3 || ni.Subversion != "/aidosd:unstable/ARI:1.0/" {
+  		t.Error("invalid networkinfo", ni)
+  	}
+
+  	if err := getnetworkinfo(conf, &Request{Method: "getnetworkinfo", Params: []interface{}{1}}, &resp); err == nil {
+  		t.Error("should be error")
+  	}
+  }
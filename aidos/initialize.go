@@ -76,21 +76,21 @@
     log.Println("## Let's get started. ")
     log.Println("## ")
     log.Println("## Enter (1) if you want to generate a NEW ACCOUNT/SEED (i.e. let aidosd generate a new seed for you), or")
-    log.Println("## Enter (2) if you want to IMPORT an EXISTING SEED.")
-    fmt.Print("## Type (1) or (2): ")
+    log.Println("## Enter (2) if you want to IMPORT an EXISTING SEED, or")
+    log.Println("## Enter (3) if you want to set up a WATCH-ONLY wallet from addresses exported by -export_addresses.")
+    fmt.Print("## Type (1), (2) or (3): ")
     char := "0"
     for {
       reader := bufio.NewReader(os.Stdin)
       char_s, _ := reader.ReadString('\n')
       char = char_s[0:1]
-      if (char == "1" || char == "2") {
+      if (char == "1" || char == "2" || char == "3") {
         break;
       }
       log.Println(" ")
       log.Println(" *** Invalid input")
-      fmt.Print("## Type (1) or (2): ")
+      fmt.Print("## Type (1), (2) or (3): ")
     }
-    log.Println("Generating seed... ")
     if (char=="1"){ // NEW SEED
        seed, _ := GenerateRandomSEED(81)
        log.Println("Generating account from random new seed")
@@ -125,6 +125,28 @@
       		log.Printf("Error parsing the seed: %v\n", err)
       		return err
       	}
+    } else if char == "3" {
+        log.Println("ENTER THE PATH OF THE ADDRESS LIST FILE:")
+        fmt.Print("-> ")
+        reader := bufio.NewReader(os.Stdin)
+        fname, _ := reader.ReadString('\n')
+        fname = strings.TrimSpace(fname)
+        f, err := os.Open(fname)
+        if err != nil {
+          log.Printf("Error opening the address list: %v\n", err)
+          return err
+        }
+        n, err := ImportAddresses(f)
+        if errr := f.Close(); err == nil {
+          err = errr
+        }
+        if err != nil {
+          log.Printf("Error importing addresses: %v\n", err)
+          return err
+        }
+        log.Println("imported", n, "addresses as watch-only")
+        loadTXs(conf_g)
+        log.Println("watch-only wallet has been set up")
     }
     SetLog(false)
     return nil
@@ -147,10 +169,7 @@
   func ScanAndRestoreAddresses(conf *Conf, seed gadk.Trytes) error {
     acc := ""
     db.Update(func(tx *bolt.Tx) error {
-      ac := &Account{
-        Name: acc,
-        Seed: seed,
-      }
+      ac := newAccount(acc, seed)
 
       log.Println("Checking for Address Balances.")
       fmt.Print("Enter how many addresses you want to scan (default 50000): ")
@@ -211,7 +230,14 @@
       }
       return putAccount(tx, ac)
     })
-    log.Println("Load complete. Now relaoding all transacations that already exist in the mesh, and store in DB")
+    log.Println("Load complete.")
+    loadTXs(conf)
+    return nil
+  }
+
+  //loadTXs loads transactions of all addresses in the wallet without calling walletnotify.
+  func loadTXs(conf *Conf) {
+    log.Println("Now relaoding all transacations that already exist in the mesh, and store in DB")
     log.Println("Please be patient, this can take a while...")
     RefreshAccount(conf)
     log.Println("TX Load complete.")
@@ -221,16 +247,12 @@
     conf.Notify = ""
     Walletnotify(conf)
     log.Println("Confirmation state update complete.")
-    return nil
   }
 
   func SetupNewAddresses(conf *Conf, seed gadk.Trytes) error {
     acc := ""
     return db.Update(func(tx *bolt.Tx) error {
-      ac := &Account{
-        Name: acc,
-        Seed: seed,
-      }
+      ac := newAccount(acc, seed)
 
       var addr_chunk []gadk.Address
       adr, _ := gadk.NewAddress(seed, 0, 2) // create one address
@@ -0,0 +1,410 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+
+  import (
+  	"encoding/json"
+  	"log"
+  	"strconv"
+  	"time"
+
+  	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
+  )
+
+  /*
+  Balances of accounts are kept in the DB as a ledger, and balance APIs answer from it
+  without asking the node.
+  Value is the confirmed balance. Spending values are deducted from Value when sent, and
+  are kept in Spent until the spending txs are confirmed.
+  Change is the pending change of bundles sent from the wallet, and Pending is the sum of
+  unconfirmed incoming values from others, which is updated by Walletnotify.
+  The ledger is reconciled with balances in the node by RunReconcile.
+  */
+
+  var ledgerDB = []byte("ledger") // Bucket name (in Bolt) for states of the ledger
+
+  var reconciledKey = []byte("reconciled")
+
+  //addressBalance is the balance of an address in the wallet.
+  type addressBalance struct {
+  	Confirmed int64
+  	Pending   int64
+  }
+
+  //total returns the balance including pending values if minconf is 0, or only the confirmed one.
+  func (b addressBalance) total(minconf int) int64 {
+  	if minconf == 0 {
+  		return b.Confirmed + b.Pending
+  	}
+  	return b.Confirmed
+  }
+
+  //ledger returns the balance of b in the ledger.
+  //A negative value, i.e. a spent change which is not confirmed yet, is deducted from pending values.
+  func (b *Balance) ledger() addressBalance {
+  	bal := addressBalance{
+  		Confirmed: b.Value,
+  		Pending:   b.Pending + b.Change,
+  	}
+  	if bal.Confirmed < 0 {
+  		bal.Pending += bal.Confirmed
+  		bal.Confirmed = 0
+  	}
+  	return bal
+  }
+
+  //getBalance returns all accounts and balances of their addresses in the ledger.
+  func getBalance(tx *bolt.Tx) ([]Account, map[gadk.Address]addressBalance, error) {
+  	acs, err := listAccount(tx)
+  	if err != nil {
+  		return nil, nil, err
+  	}
+  	balmap := make(map[gadk.Address]addressBalance)
+  	for _, ac := range acs {
+  		for i := range ac.Balances {
+  			balmap[ac.Balances[i].Address] = ac.Balances[i].ledger()
+  		}
+  	}
+  	return acs, balmap, nil
+  }
+
+  //isOwnBundle returns true if the bundle spends tokens of the wallet.
+  //Incoming values of such bundles are changes.
+  func isOwnBundle(tx *bolt.Tx, bundle gadk.Trytes) (bool, error) {
+  	trs, err := getTXs(tx, hashesByBundle(tx, bundle))
+  	if err != nil {
+  		return false, err
+  	}
+  	for _, tr := range trs {
+  		if tr.Value >= 0 {
+  			continue
+  		}
+  		ac, _, err := findAddress(tx, tr.Address)
+  		if err != nil {
+  			return false, err
+  		}
+  		if ac != nil {
+  			return true, nil
+  		}
+  	}
+  	return false, nil
+  }
+
+  //ledgerEntry is a tx of an address. Reattached txs are one entry,
+  //which is confirmed if any of attachments is confirmed.
+  type ledgerEntry struct {
+  	tr        *gadk.Transaction
+  	confirmed bool
+  }
+
+  func entryKey(tr *gadk.Transaction) string {
+  	return string(tr.Bundle) + strconv.FormatInt(tr.CurrentIndex, 10)
+  }
+
+  //entriesOf returns txs of adr in the DB by their bundle hashes and indice.
+  func entriesOf(tx *bolt.Tx, adr gadk.Address) (map[string]*ledgerEntry, error) {
+  	entries := make(map[string]*ledgerEntry)
+  	for _, h := range hashesByAddress(tx, adr) {
+  		tr, err := getTX(tx, h)
+  		if err != nil {
+  			return nil, err
+  		}
+  		st, err := getHash(tx, h)
+  		if err != nil {
+  			return nil, err
+  		}
+  		key := entryKey(tr)
+  		e, ok := entries[key]
+  		if !ok {
+  			e = &ledgerEntry{tr: tr}
+  			entries[key] = e
+  		}
+  		e.confirmed = e.confirmed || (st != nil && st.Confirmed)
+  	}
+  	return entries, nil
+  }
+
+  //pendingOf returns the sum of unconfirmed incoming values of adr from others.
+  func pendingOf(tx *bolt.Tx, adr gadk.Address) (int64, error) {
+  	entries, err := entriesOf(tx, adr)
+  	if err != nil {
+  		return 0, err
+  	}
+  	var pending int64
+  	for _, e := range entries {
+  		if e.confirmed || e.tr.Value <= 0 {
+  			continue
+  		}
+  		own, err := isOwnBundle(tx, e.tr.Bundle)
+  		if err != nil {
+  			return 0, err
+  		}
+  		if !own {
+  			pending += e.tr.Value
+  		}
+  	}
+  	return pending, nil
+  }
+
+  //updatePending updates pending values of adrs in the ledger.
+  func updatePending(tx *bolt.Tx, adrs map[gadk.Address]struct{}) error {
+  	acs := make(map[string]*Account)
+  	for adr := range adrs {
+  		ac, _, err := findAddress(tx, adr)
+  		if err != nil {
+  			return err
+  		}
+  		if ac == nil {
+  			continue
+  		}
+  		if a, ok := acs[ac.Name]; ok {
+  			ac = a
+  		}
+  		acs[ac.Name] = ac
+  		p, err := pendingOf(tx, adr)
+  		if err != nil {
+  			return err
+  		}
+  		ac.Balances[ac.search(adr)].Pending = p
+  	}
+  	for _, ac := range acs {
+  		if err := putAccount(tx, ac); err != nil {
+  			return err
+  		}
+  	}
+  	return nil
+  }
+
+  //rebuildLedger rebuilds Value, Spent and Pending of all addresses from txs in the DB and
+  //bundles in the outbox, for wallets made before the ledger. Spent is the sum of unconfirmed inputs
+  //of pending bundles, and Value is the sum of confirmed txs minus Spent.
+  //Txs which are not in the DB yet are corrected later by reconcile.
+  func rebuildLedger(tx *bolt.Tx) error {
+  	ba := tx.Bucket(accountDB)
+  	if ba == nil {
+  		return nil
+  	}
+  	//pendingOf finds accounts by the address index in memory, which is not loaded yet.
+  	if err := loadAddressIndex(tx); err != nil {
+  		return err
+  	}
+  	outs, err := listOutgoing(tx, false)
+  	if err != nil {
+  		return err
+  	}
+  	inputs := make(map[gadk.Address]map[string]int64)
+  	for _, o := range outs {
+  		bd, err := decodeRaw(string(o.Raw))
+  		if err != nil {
+  			log.Println("skipping invalid bundle", o.Hash, "in the outbox:", err)
+  			continue
+  		}
+  		for i := range bd {
+  			if bd[i].Value >= 0 {
+  				continue
+  			}
+  			if inputs[bd[i].Address] == nil {
+  				inputs[bd[i].Address] = make(map[string]int64)
+  			}
+  			inputs[bd[i].Address][entryKey(&bd[i])] = -bd[i].Value
+  		}
+  	}
+  	var acs []Account
+  	err = ba.ForEach(func(k, v []byte) error {
+  		var ac Account
+  		if err := json.Unmarshal(v, &ac); err != nil {
+  			return err
+  		}
+  		acs = append(acs, ac)
+  		return nil
+  	})
+  	if err != nil {
+  		return err
+  	}
+  	for i := range acs {
+  		for j := range acs[i].Balances {
+  			b := &acs[i].Balances[j]
+  			entries, err := entriesOf(tx, b.Address)
+  			if err != nil {
+  				return err
+  			}
+  			var confirmed, spent int64
+  			for _, e := range entries {
+  				if e.confirmed {
+  					confirmed += e.tr.Value
+  				}
+  			}
+  			for key, v := range inputs[b.Address] {
+  				if e, ok := entries[key]; !ok || !e.confirmed {
+  					spent += v
+  				}
+  			}
+  			if b.Pending, err = pendingOf(tx, b.Address); err != nil {
+  				return err
+  			}
+  			b.Value = confirmed - spent
+  			b.Spent = spent
+  		}
+  		if err := putAccount(tx, &acs[i]); err != nil {
+  			return err
+  		}
+  	}
+  	return nil
+  }
+
+  //lastReconciled returns the time when the ledger was reconciled last time, or zero time if never.
+  func lastReconciled(tx *bolt.Tx) time.Time {
+  	var t time.Time
+  	b := tx.Bucket(ledgerDB)
+  	if b == nil {
+  		return t
+  	}
+  	if err := t.UnmarshalText(b.Get(reconciledKey)); err != nil {
+  		return time.Time{}
+  	}
+  	return t
+  }
+
+  func putReconciled(tx *bolt.Tx, t time.Time) error {
+  	b, err := tx.CreateBucketIfNotExists(ledgerDB)
+  	if err != nil {
+  		return err
+  	}
+  	bin, err := t.MarshalText()
+  	if err != nil {
+  		return err
+  	}
+  	return b.Put(reconciledKey, bin)
+  }
+
+  //lastReconciledUnix returns the unix time of the last reconciliation, or 0 if never.
+  func lastReconciledUnix(tx *bolt.Tx) int64 {
+  	if r := lastReconciled(tx); !r.IsZero() {
+  		return r.Unix()
+  	}
+  	return 0
+  }
+
+  //drifts has differences between balances in the node and in the ledger found by the last reconciliation.
+  //It is protected by mutex.
+  var drifts = make(map[gadk.Address]int64)
+
+  //Reconcile reconciles the ledger with the node once. It should be called before serving APIs,
+  //so that differences in the ledger (e.g. one rebuilt by the migration) are found first.
+  func Reconcile(conf *Conf) error {
+  	if conf.ReconcileInterval <= 0 {
+  		return nil
+  	}
+  	return reconcile(conf)
+  }
+
+  //RunReconcile reconciles the ledger with the node every conf.ReconcileInterval after Reconcile.
+  func RunReconcile(conf *Conf) {
+  	if conf.ReconcileInterval <= 0 {
+  		return
+  	}
+  	for {
+  		time.Sleep(conf.ReconcileInterval)
+  		if err := reconcile(conf); err != nil {
+  			log.Println("failed to reconcile balances:", err)
+  		}
+  	}
+  }
+
+  //reconcile compares confirmed balances in the ledger with ones in the node. A difference is corrected only if
+  //the same one was found by the last reconciliation too, because it may be caused by txs which
+  //Walletnotify has not handled yet.
+  //Balances are fetched from the node without locking mutex, so that APIs are not blocked meanwhile.
+  func reconcile(conf *Conf) error {
+  	var adrs []gadk.Address
+  	err := db.View(func(tx *bolt.Tx) error {
+  		acs, err := listAccount(tx)
+  		if err != nil {
+  			return err
+  		}
+  		for _, ac := range acs {
+  			for _, b := range ac.Balances {
+  				adrs = append(adrs, b.Address)
+  			}
+  		}
+  		return nil
+  	})
+  	if err != nil {
+  		return err
+  	}
+  	nodeBals := make(map[gadk.Address]int64, len(adrs))
+  	for i := 0; i < len(adrs); i += 100 {
+  		j := i + 100
+  		if j > len(adrs) {
+  			j = len(adrs)
+  		}
+  		bals, err := conf.api.Balances(adrs[i:j])
+  		if err != nil {
+  			return err
+  		}
+  		for _, b := range bals {
+  			nodeBals[b.Address] = b.Value
+  		}
+  	}
+
+  	mutex.Lock()
+  	defer mutex.Unlock()
+  	return db.Update(func(tx *bolt.Tx) error {
+  		acs, err := listAccount(tx)
+  		if err != nil {
+  			return err
+  		}
+  		found := make(map[gadk.Address]int64)
+  		for _, ac := range acs {
+  			changed := false
+  			for i := range ac.Balances {
+  				b := &ac.Balances[i]
+  				v, ok := nodeBals[b.Address]
+  				if !ok {
+  					continue //added after fetching balances
+  				}
+  				//spent values are still in the node until the spending txs are confirmed,
+  				//and the node may count unconfirmed incoming values.
+  				diff := v - (b.Value + b.Spent)
+  				if diff == 0 || diff == b.Pending+b.Change {
+  					continue
+  				}
+  				if drifts[b.Address] != diff {
+  					log.Println("balance of", b.Address, "differs from the node by", diff)
+  					found[b.Address] = diff
+  					continue
+  				}
+  				log.Println("correcting balance of", b.Address, "by", diff)
+  				b.Value += diff
+  				changed = true
+  			}
+  			if !changed {
+  				continue
+  			}
+  			if err := putAccount(tx, &ac); err != nil {
+  				return err
+  			}
+  		}
+  		drifts = found
+  		return putReconciled(tx, time.Now())
+  	})
+  }
@@ -0,0 +1,199 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+
+  import (
+  	"encoding/json"
+  	"strings"
+  	"testing"
+  	"time"
+
+  	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
+  )
+
+  func TestReconcile(t *testing.T) {
+  	conf := prepareTest(t)
+  	adr1 := gadk.Address("A") + gadk.EmptyAddress[1:]
+  	adr2 := gadk.Address("B") + gadk.EmptyAddress[1:]
+  	ac := &Account{
+  		Name: "ac",
+  		Balances: []Balance{
+  			{Balance: gadk.Balance{Address: adr1, Value: 100}},
+  			{Balance: gadk.Balance{Address: adr2}, Spent: 50, Pending: 20},
+  		},
+  	}
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		return putAccount(tx, ac)
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	d1 := newdummy(map[string][]gadk.Address{"ac": {adr1, adr2}}, t)
+  	d1.vals[adr1] = 120
+  	//spent values which are not confirmed and pending values are in the node.
+  	d1.vals[adr2] = 70
+  	conf.api = d1
+
+  	check := func(balance int64, reconciled bool) {
+  		req := &Request{
+  			JSONRPC: "2.0",
+  			ID:      "curltest",
+  			Method:  "getbalance",
+  			Params:  []interface{}{"ac"},
+  		}
+  		var resp Response
+  		if err := getbalance(conf, req, &resp); err != nil {
+  			t.Fatal(err)
+  		}
+  		if resp.Result != Amount(balance) {
+  			t.Error("invalid balance", resp.Result, balance)
+  		}
+  		bin, err := json.Marshal(resp)
+  		if err != nil {
+  			t.Fatal(err)
+  		}
+  		if strings.Contains(string(bin), `"reconciled"`) {
+  			t.Error("the envelope must not be extended", string(bin))
+  		}
+  		req.Method = "getwalletinfo"
+  		req.Params = []interface{}{}
+  		resp = Response{}
+  		if err := getwalletinfo(conf, req, &resp); err != nil {
+  			t.Fatal(err)
+  		}
+  		if wi := resp.Result.(*walletinfo); (wi.LastReconcile != 0) != reconciled {
+  			t.Error("invalid reconciled time", wi.LastReconcile)
+  		}
+  	}
+  	check(100, false)
+  	//the difference may be caused by txs which are not handled yet.
+  	if err := reconcile(conf); err != nil {
+  		t.Fatal(err)
+  	}
+  	check(100, true)
+  	if err := reconcile(conf); err != nil {
+  		t.Fatal(err)
+  	}
+  	check(120, true)
+  	if err := reconcile(conf); err != nil {
+  		t.Fatal(err)
+  	}
+  	check(120, true)
+  }
+
+  func TestRebuildLedger(t *testing.T) {
+  	prepareTest(t)
+  	adr1 := gadk.Address("A") + gadk.EmptyAddress[1:]
+  	adr2 := gadk.Address("B") + gadk.EmptyAddress[1:]
+  	other := gadk.Address("C") + gadk.EmptyAddress[1:]
+  	ac := &Account{
+  		Name: "ac",
+  		Balances: []Balance{
+  			{Balance: gadk.Balance{Address: adr1, Value: 999}},
+  			{Balance: gadk.Balance{Address: adr2}},
+  		},
+  	}
+  	//a confirmed deposit to adr1 and an unconfirmed one to adr2.
+  	var in1, in2 gadk.Bundle
+  	in1.Add(1, adr1, 100, time.Now(), gadk.EmptyHash)
+  	in1.Add(2, other, -100, time.Now(), gadk.EmptyHash)
+  	in1.Finalize(nil)
+  	in2.Add(1, adr2, 20, time.Now(), gadk.EmptyHash)
+  	in2.Add(2, other, -20, time.Now(), gadk.EmptyHash)
+  	in2.Finalize(nil)
+  	//a bundle sent from adr1 with a change to adr2, which is not confirmed yet.
+  	var out gadk.Bundle
+  	out.Add(1, other, 70, time.Now(), gadk.EmptyHash)
+  	out.Add(2, adr1, -100, time.Now(), gadk.EmptyHash)
+  	out.Add(1, adr2, 30, time.Now(), gadk.EmptyHash)
+  	out.Finalize(nil)
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		if err := putAccount(tx, ac); err != nil {
+  			return err
+  		}
+  		for _, bd := range []gadk.Bundle{in1, in2, out} {
+  			for i := range bd {
+  				if err := putTX(tx, &bd[i]); err != nil {
+  					return err
+  				}
+  				st := &txstate{
+  					Hash:      bd[i].Hash(),
+  					Confirmed: bd[i].Bundle == in1.Hash(),
+  				}
+  				if err := putHash(tx, st); err != nil {
+  					return err
+  				}
+  			}
+  		}
+  		if err := putOutgoing(tx, &outgoing{
+  			Hash:    out.Hash(),
+  			Account: "ac",
+  			Amount:  70,
+  			Raw:     encodeRaw(out),
+  			State:   outBroadcast,
+  			Created: time.Now(),
+  		}); err != nil {
+  			return err
+  		}
+  		return rebuildLedger(tx)
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		ac2, err := getAccount(tx, "ac")
+  		if err != nil {
+  			return err
+  		}
+  		b1, b2 := ac2.Balances[0], ac2.Balances[1]
+  		if b1.Value != 0 || b1.Spent != 100 || b1.Pending != 0 {
+  			t.Error("invalid ledger of the spent address", b1)
+  		}
+  		//the change is not a pending value from others.
+  		if b2.Value != 0 || b2.Spent != 0 || b2.Pending != 20 {
+  			t.Error("invalid ledger of the deposit address", b2)
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  }
+
+  func TestLedger(t *testing.T) {
+  	tests := []struct {
+  		bal       Balance
+  		confirmed int64
+  		pending   int64
+  	}{
+  		{Balance{Balance: gadk.Balance{Value: 100}, Spent: 30}, 100, 0},
+  		{Balance{Balance: gadk.Balance{Value: 100}, Change: 10, Pending: 20}, 100, 30},
+  		//spent pending change
+  		{Balance{Balance: gadk.Balance{Value: -10}, Change: 10, Spent: 110}, 0, 0},
+  	}
+  	for i, tt := range tests {
+  		b := tt.bal.ledger()
+  		if b.Confirmed != tt.confirmed || b.Pending != tt.pending {
+  			t.Error(i, "invalid ledger", b)
+  		}
+  		if b.total(0) != tt.confirmed+tt.pending || b.total(1) != tt.confirmed {
+  			t.Error(i, "invalid total", b.total(0), b.total(1))
+  		}
+  	}
+  }
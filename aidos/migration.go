@@ -0,0 +1,201 @@
+  // Copyright (c) 2017 Aidos Developer
+
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+
+  package aidos
+
+  import (
+  	"crypto/sha256"
+  	"encoding/binary"
+  	"errors"
+  	"fmt"
+  	"log"
+  	"sort"
+  	"strings"
+
+  	"github.com/boltdb-go/bolt"
+  )
+
+  var (
+  	metaDB     = []byte("meta")
+  	versionKey = []byte("schema_version")
+  )
+
+  type migration struct {
+  	description string
+  	migrate     func(tx *bolt.Tx) error
+  }
+
+  //migrations[i] migrates the DB schema from version i to i+1.
+  //Add a new migration at the end when changing the schema, and never reorder them.
+  var migrations = []migration{
+  	{"move tx states to per-hash keys and index txs by bundle and address", migrateHashDB},
+  	{"index addresses to accounts", buildAddressIndex},
+  	{"count tx states", buildHashCount},
+  	{"rebuild the ledger from txs and the outbox", rebuildLedger},
+  }
+
+  var errDryRun = errors.New("dry run")
+
+  func schemaVersion(tx *bolt.Tx) uint64 {
+  	b := tx.Bucket(metaDB)
+  	if b == nil {
+  		return 0
+  	}
+  	v := b.Get(versionKey)
+  	if len(v) != 8 {
+  		return 0
+  	}
+  	return binary.BigEndian.Uint64(v)
+  }
+
+  func putSchemaVersion(tx *bolt.Tx, ver uint64) error {
+  	b, err := tx.CreateBucketIfNotExists(metaDB)
+  	if err != nil {
+  		return err
+  	}
+  	v := make([]byte, 8)
+  	binary.BigEndian.PutUint64(v, ver)
+  	return b.Put(versionKey, v)
+  }
+
+  //migrate runs migrations from the current schema version, and returns descriptions of them.
+  //If withEffects, changes of buckets made by each migration are also described.
+  func migrate(tx *bolt.Tx, withEffects bool) ([]string, error) {
+  	ver := schemaVersion(tx)
+  	if ver > uint64(len(migrations)) {
+  		return nil, fmt.Errorf("DB schema version %d is newer than supported version %d", ver, len(migrations))
+  	}
+  	var done []string
+  	for ; ver < uint64(len(migrations)); ver++ {
+  		m := migrations[ver]
+  		log.Printf("migrating DB schema from version %d: %s", ver, m.description)
+  		var before map[string]bucketState
+  		if withEffects {
+  			before = bucketStates(tx)
+  		}
+  		if err := m.migrate(tx); err != nil {
+  			return nil, err
+  		}
+  		d := fmt.Sprintf("%d -> %d: %s", ver, ver+1, m.description)
+  		if withEffects {
+  			d += " (" + bucketEffects(before, bucketStates(tx)) + ")"
+  		}
+  		done = append(done, d)
+  	}
+  	if len(done) == 0 {
+  		return nil, nil
+  	}
+  	return done, putSchemaVersion(tx, ver)
+  }
+
+  //Migrate migrates the DB to the latest schema in one transaction.
+  func Migrate() error {
+  	return db.Update(func(tx *bolt.Tx) error {
+  		_, err := migrate(tx, false)
+  		return err
+  	})
+  }
+
+  //MigrateDryRun runs migrations and rollbacks them, and returns descriptions of them with their effects.
+  //Nothing is written to the DB, so the password is not needed.
+  func MigrateDryRun() ([]string, error) {
+  	setDB()
+  	return migrateDryRun()
+  }
+
+  func migrateDryRun() ([]string, error) {
+  	var done []string
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		var err error
+  		if done, err = migrate(tx, true); err != nil {
+  			return err
+  		}
+  		return errDryRun
+  	})
+  	if err != errDryRun {
+  		return nil, err
+  	}
+  	return done, nil
+  }
+
+  //bucketState is the number of keys and a digest of keys and values in a bucket.
+  type bucketState struct {
+  	keys int
+  	sum  [sha256.Size]byte
+  }
+
+  //bucketStates returns states of all top-level buckets.
+  func bucketStates(tx *bolt.Tx) map[string]bucketState {
+  	states := make(map[string]bucketState)
+  	//ForEach never returns errors because the callbacks don't.
+  	_ = tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+  		var st bucketState
+  		h := sha256.New()
+  		l := make([]byte, 4)
+  		_ = b.ForEach(func(k, v []byte) error {
+  			st.keys++
+  			for _, bs := range [][]byte{k, v} {
+  				binary.BigEndian.PutUint32(l, uint32(len(bs)))
+  				h.Write(l)
+  				h.Write(bs)
+  			}
+  			return nil
+  		})
+  		copy(st.sum[:], h.Sum(nil))
+  		states[string(name)] = st
+  		return nil
+  	})
+  	return states
+  }
+
+  //bucketEffects describes differences of buckets between before and after.
+  func bucketEffects(before, after map[string]bucketState) string {
+  	names := make(map[string]struct{})
+  	for n := range before {
+  		names[n] = struct{}{}
+  	}
+  	for n := range after {
+  		names[n] = struct{}{}
+  	}
+  	sorted := make([]string, 0, len(names))
+  	for n := range names {
+  		sorted = append(sorted, n)
+  	}
+  	sort.Strings(sorted)
+  	var effects []string
+  	for _, n := range sorted {
+  		b, okb := before[n]
+  		a, oka := after[n]
+  		switch {
+  		case !okb:
+  			effects = append(effects, fmt.Sprintf("%s: created with %d keys", n, a.keys))
+  		case !oka:
+  			effects = append(effects, fmt.Sprintf("%s: removed %d keys", n, b.keys))
+  		case a.keys != b.keys:
+  			effects = append(effects, fmt.Sprintf("%s: %d -> %d keys", n, b.keys, a.keys))
+  		case a.sum != b.sum:
+  			effects = append(effects, fmt.Sprintf("%s: values modified (%d keys)", n, a.keys))
+  		}
+  	}
+  	if len(effects) == 0 {
+  		return "no changes"
+  	}
+  	return strings.Join(effects, ", ")
+  }
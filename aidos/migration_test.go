@@ -0,0 +1,111 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+
+  package aidos
+
+  import (
+  	"encoding/json"
+  	"strings"
+  	"testing"
+
+  	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
+  )
+
+  func TestMigrate(t *testing.T) {
+  	prepareTest(t)
+  	version := func() uint64 {
+  		var ver uint64
+  		if err := db.View(func(tx *bolt.Tx) error {
+  			ver = schemaVersion(tx)
+  			return nil
+  		}); err != nil {
+  			t.Fatal(err)
+  		}
+  		return ver
+  	}
+  	legacy := func() bool {
+  		var exist bool
+  		if err := db.View(func(tx *bolt.Tx) error {
+  			exist = tx.Bucket(hashDB).Get(hashDB) != nil
+  			return nil
+  		}); err != nil {
+  			t.Fatal(err)
+  		}
+  		return exist
+  	}
+  	if v := version(); v != uint64(len(migrations)) {
+  		t.Error("invalid version", v)
+  	}
+
+  	//make an old DB
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		if err := putSchemaVersion(tx, 0); err != nil {
+  			return err
+  		}
+  		b, err := tx.CreateBucketIfNotExists(hashDB)
+  		if err != nil {
+  			return err
+  		}
+  		bin, err := json.Marshal([]*txstate{{Hash: gadk.EmptyHash}})
+  		if err != nil {
+  			return err
+  		}
+  		return b.Put(hashDB, bin)
+  	})
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+
+  	done, err := migrateDryRun()
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	if len(done) != len(migrations) {
+  		t.Fatal("invalid number of migrations", done)
+  	}
+  	//the legacy key is removed and txstates are stored per hash.
+  	if !strings.Contains(done[0], "hash_sequences: created with 1 keys") || !strings.Contains(done[0], "hashes: values modified") {
+  		t.Error("effects must be described", done[0])
+  	}
+  	if v := version(); v != 0 || !legacy() {
+  		t.Error("dry run must not change the DB", v)
+  	}
+
+  	if err = Migrate(); err != nil {
+  		t.Fatal(err)
+  	}
+  	if v := version(); v != uint64(len(migrations)) || legacy() {
+  		t.Error("DB must be migrated", v)
+  	}
+  	if done, err = migrateDryRun(); err != nil || len(done) != 0 {
+  		t.Error("nothing should be done", done, err)
+  	}
+
+  	if err = db.Update(func(tx *bolt.Tx) error {
+  		return putSchemaVersion(tx, uint64(len(migrations)+1))
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	if err = Migrate(); err == nil {
+  		t.Error("newer version should be error")
+  	}
+  }
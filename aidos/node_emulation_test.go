@@ -46,12 +46,12 @@
   	stored      []gadk.Transaction
   }
   
-  func (d *dummy1) bundleAmount() float64 {
+  func (d *dummy1) bundleAmount() Amount {
   	var amount int64
   	for _, tx := range d.bundle {
   		amount += tx.Value
   	}
-  	return float64(amount) / 100000000
+  	return Amount(amount)
   }
   func (d *dummy1) list(ac string, count, skip int) []*gadk.Transaction {
   	var res []*gadk.Transaction
@@ -84,6 +84,33 @@
   	return res
   }
   
+  //balance returns confirmed and pending balances of adr which should be reported after Walletnotify.
+  //Bundles which have negative values are regarded as ones sent from the wallet, and their incoming
+  //values are not pending ones but changes.
+  func (d *dummy1) balance(adr gadk.Address) (int64, int64) {
+  	all := d.listall()
+  	for i := range d.bundle {
+  		all = append(all, &d.bundle[i])
+  	}
+  	own := make(map[gadk.Trytes]bool)
+  	for _, tx := range all {
+  		if tx.Value < 0 {
+  			own[tx.Bundle] = true
+  		}
+  	}
+  	var confirmed, pending int64
+  	for _, tx := range all {
+  		switch {
+  		case tx.Address != adr:
+  		case d.isConf:
+  			confirmed += tx.Value
+  		case tx.Value > 0 && !own[tx.Bundle]:
+  			pending += tx.Value
+  		}
+  	}
+  	return confirmed, pending
+  }
+
   func newdummy(accadr map[string][]gadk.Address, t *testing.T) *dummy1 {
   	rand.Seed(time.Now().Unix())
   	adr2acc := make(map[gadk.Address]string)
@@ -115,10 +142,11 @@
   			val := int64(rand.Int31() - math.MaxInt32/2)
   			sum += val
   			tx := &gadk.Transaction{
-  				Address:   adr,
-  				Value:     val,
-  				Timestamp: time.Now().Add(time.Duration(rand.Int31()-math.MaxInt32/2) * time.Second),
-  				Bundle:    gadk.Trytes("B"+c[i%3]) + gadk.EmptyHash[2:],
+  				Address:      adr,
+  				Value:        val,
+  				Timestamp:    time.Now().Add(time.Duration(rand.Int31()-math.MaxInt32/2) * time.Second),
+  				Bundle:       gadk.Trytes("B"+c[i%3]) + gadk.EmptyHash[2:],
+  				CurrentIndex: int64(i),
   			}
   			if i == 4 {
   				for sum < 0.2*100000000 {
@@ -250,6 +278,13 @@
   	}, nil
   }
   func (d *dummy1) GetNodeInfo() (*gadk.GetNodeInfoResponse, error) {
-  	return &gadk.GetNodeInfoResponse{}, nil
+  	return &gadk.GetNodeInfoResponse{
+  		AppName:                            "ARI",
+  		AppVersion:                         "1.0",
+  		LatestMilestone:                    trunk,
+  		LatestMilestoneIndex:               100,
+  		LatestSolidSubtangleMilestoneIndex: 100,
+  		Neighbors:                          3,
+  	}, nil
   }
   
\ No newline at end of file
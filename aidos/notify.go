@@ -42,15 +42,17 @@
   		}
   		news = make([]*txstate, 0, len(hashes))
   		nhashes := make([]gadk.Trytes, 0, len(hashes))
+  		exist := make(map[gadk.Trytes]struct{}, len(hashes))
   		for _, h1 := range hashes {
-  			exist := false
-  			for _, h2 := range hs {
-  				if h1 == h2.Hash {
-  					exist = true
-  					break
-  				}
+  			if _, ok := exist[h1]; ok {
+  				continue
+  			}
+  			exist[h1] = struct{}{}
+  			h2, err := getHash(tx, h1)
+  			if err != nil {
+  				return err
   			}
-  			if !exist {
+  			if h2 == nil {
   				news = append(news, &txstate{Hash: h1})
   				nhashes = append(nhashes, h1)
   			}
@@ -111,7 +113,16 @@
 		}
 
   	err = db.Update(func(tx *bolt.Tx) error {
-  		return putHashes(tx, hs)
+  		//store only new or newly confirmed ones.
+  		for _, h := range hs {
+  			if h.Seq != 0 && (!h.Confirmed || h.ConfSeq != 0) {
+  				continue
+  			}
+  			if err := putHash(tx, h); err != nil {
+  				return err
+  			}
+  		}
+  		return nil
   	})
   	if err != nil {
   		return nil, nil, err
@@ -217,11 +228,13 @@
   		if err != nil {
   			return err
   		}
+  		touched := make(map[gadk.Address]struct{})
   		for _, tr := range trs {
   			if tr.Value == 0 {
   				continue
   			}
   			bdls[tr.Bundle] = struct{}{}
+  			touched[tr.Address] = struct{}{}
   			acc, index, errr := findAddress(tx, tr.Address)
   			if errr != nil {
   				log.Println(errr)
@@ -231,10 +244,21 @@
   				log.Println("acc shoud not be null")
   				continue
   			}
-  			acc.Balances[index].Value += tr.Value
-  			acc.Balances[index].Change = 0
-  			if errrr := putAccount(tx, acc); err != nil {
-  				return errrr
+  			b := &acc.Balances[index]
+  			value := tr.Value
+  			//spending values were already deducted when sent.
+  			if value < 0 && b.Spent > 0 {
+  				spent := -value
+  				if spent > b.Spent {
+  					spent = b.Spent
+  				}
+  				b.Spent -= spent
+  				value += spent
+  			}
+  			b.Value += value
+  			b.Change = 0
+  			if err := putAccount(tx, acc); err != nil {
+  				return err
   			}
   		}
   		//add bundle hash to bdls.
@@ -245,9 +269,10 @@
   		for _, tr := range nresp.Trytes {
   			if tr.Value != 0 {
   				bdls[tr.Bundle] = struct{}{}
+  				touched[tr.Address] = struct{}{}
   			}
   		}
-  		return nil
+  		return updatePending(tx, touched)
   	})
   	if err != nil {
   		log.Println(err)
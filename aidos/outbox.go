@@ -0,0 +1,460 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+  import (
+  	"encoding/json"
+  	"log"
+  	"sort"
+  	"sync"
+  	"time"
+
+  	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
+  )
+
+  var outboxDB = []byte("outbox") // Bucket name (in Bolt) for outgoing bundles, bundle hash -> outgoing
+
+  //States of outgoing bundles. They move as prepared -> pow-done -> broadcast -> confirmed,
+  //or to failed when abandoned or invalid. Bundles made by fundrawtransaction are funded until
+  //they are broadcasted by sendrawtransaction.
+  const (
+  	outFunded    = "funded"
+  	outPrepared  = "prepared"
+  	outPowDone   = "pow-done"
+  	outBroadcast = "broadcast"
+  	outConfirmed = "confirmed"
+  	outFailed    = "failed"
+  )
+
+  //outgoing is a bundle sent from the wallet.
+  type outgoing struct {
+  	Hash     gadk.Trytes //bundle hash
+  	Account  string
+  	Amount   int64
+  	Raw      gadk.Trytes //trytes of the bundle, with nonces of the last attachment after PoW
+  	State    string
+  	Attempts int
+  	Error    string        `json:",omitempty"`
+  	Tails    []gadk.Trytes `json:",omitempty"` //hashes of tail txs of all attachments
+  	Payout   gadk.Trytes   `json:",omitempty"` //hash of the first bundle if a payout is split into several bundles
+  	Attached time.Time     //time of the last attachment
+  	Created  time.Time
+  	Updated  time.Time
+  }
+
+  func (o *outgoing) isPending() bool {
+  	return o.State != outConfirmed && o.State != outFailed
+  }
+
+  func getOutgoing(tx *bolt.Tx, hash gadk.Trytes) (*outgoing, error) {
+  	b := tx.Bucket(outboxDB)
+  	if b == nil {
+  		return nil, nil
+  	}
+  	v := b.Get([]byte(hash))
+  	if v == nil {
+  		return nil, nil
+  	}
+  	var o outgoing
+  	if err := json.Unmarshal(v, &o); err != nil {
+  		return nil, err
+  	}
+  	return &o, nil
+  }
+
+  func putOutgoing(tx *bolt.Tx, o *outgoing) error {
+  	b, err := tx.CreateBucketIfNotExists(outboxDB)
+  	if err != nil {
+  		return err
+  	}
+  	o.Updated = time.Now()
+  	bin, err := json.Marshal(o)
+  	if err != nil {
+  		return err
+  	}
+  	return b.Put([]byte(o.Hash), bin)
+  }
+
+  //setPayout marks bundles of a split payout with the hash of the first one.
+  func setPayout(tx *bolt.Tx, hashes []gadk.Trytes) error {
+  	for _, h := range hashes {
+  		o, err := getOutgoing(tx, h)
+  		if err != nil {
+  			return err
+  		}
+  		o.Payout = hashes[0]
+  		if err := putOutgoing(tx, o); err != nil {
+  			return err
+  		}
+  	}
+  	return nil
+  }
+
+  //listOutgoing returns outgoing bundles in the order of creation.
+  //If all is false, only pending ones are returned.
+  func listOutgoing(tx *bolt.Tx, all bool) ([]*outgoing, error) {
+  	b := tx.Bucket(outboxDB)
+  	if b == nil {
+  		return nil, nil
+  	}
+  	var outs []*outgoing
+  	err := b.ForEach(func(k, v []byte) error {
+  		var o outgoing
+  		if err := json.Unmarshal(v, &o); err != nil {
+  			return err
+  		}
+  		if all || o.isPending() {
+  			outs = append(outs, &o)
+  		}
+  		return nil
+  	})
+  	if err != nil {
+  		return nil, err
+  	}
+  	sort.Slice(outs, func(i, j int) bool {
+  		return outs[i].Created.Before(outs[j].Created)
+  	})
+  	return outs, nil
+  }
+
+  //outboxMutex protects processing.
+  var outboxMutex sync.Mutex
+
+  //processing has hashes of bundles being processed, so that each bundle is processed by one goroutine at a time.
+  var processing = make(map[gadk.Trytes]struct{})
+
+  //stateMutex is locked while checking and changing states of outgoing bundles,
+  //so that a bundle being abandoned is never broadcasted.
+  var stateMutex sync.Mutex
+
+  //RunOutbox processes pending bundles in the outbox, including ones left by the previous run,
+  //and retries failed PoW or broadcasting every 3 minutes.
+  func RunOutbox(conf *Conf) {
+  	for {
+  		processOutbox(conf)
+  		time.Sleep(3 * time.Minute)
+  	}
+  }
+
+  //processOutbox does PoW and broadcasting of pending bundles, and checks confirmations of broadcasted ones.
+  //Bundles are independent each other, so up to cap(conf.outboxSlots) bundles are processed concurrently
+  //in order of creation. It returns after all bundles it started are processed.
+  func processOutbox(conf *Conf) {
+  	var outs []*outgoing
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		var err error
+  		outs, err = listOutgoing(tx, false)
+  		return err
+  	}); err != nil {
+  		log.Println(err)
+  		return
+  	}
+  	var wg sync.WaitGroup
+  	for _, o := range outs {
+  		outboxMutex.Lock()
+  		_, exist := processing[o.Hash]
+  		if !exist {
+  			processing[o.Hash] = struct{}{}
+  		}
+  		outboxMutex.Unlock()
+  		if exist {
+  			continue
+  		}
+  		conf.outboxSlots <- struct{}{}
+  		wg.Add(1)
+  		go func(hash gadk.Trytes) {
+  			defer func() {
+  				<-conf.outboxSlots
+  				outboxMutex.Lock()
+  				delete(processing, hash)
+  				outboxMutex.Unlock()
+  				wg.Done()
+  			}()
+  			//reload the bundle, which may be processed by another goroutine after listed.
+  			var o *outgoing
+  			err := db.View(func(tx *bolt.Tx) error {
+  				var err error
+  				o, err = getOutgoing(tx, hash)
+  				return err
+  			})
+  			if err == nil && o != nil && o.isPending() {
+  				err = processOutgoing(conf, o)
+  			}
+  			if err != nil {
+  				log.Println("failed to send", hash, ":", err)
+  			}
+  		}(o.Hash)
+  	}
+  	wg.Wait()
+  }
+
+  func processOutgoing(conf *Conf, o *outgoing) error {
+  	bd, err := decodeRaw(string(o.Raw))
+  	if err != nil {
+  		return setOutgoingState(o, outFailed, err)
+  	}
+  	ts := []gadk.Transaction(bd)
+  	switch o.State {
+  	case outPrepared:
+  		log.Println("starting PoW... (", conf.pow.Info(), ")")
+  		err = PowTrytes(conf.api, gadk.Depth, ts, conf.mwm(), conf.pow)
+  		if err != nil {
+  			return setOutgoingState(o, o.State, err)
+  		}
+  		log.Println("finished PoW...")
+  		o.Raw = encodeRaw(bd)
+  		if err = setOutgoingState(o, outPowDone, nil); err != nil {
+  			return err
+  		}
+  		fallthrough
+  	case outPowDone:
+  		stateMutex.Lock()
+  		err = broadcastOutgoing(conf, o, ts)
+  		stateMutex.Unlock()
+  		if err != nil {
+  			return err
+  		}
+  		log.Println("finish sending. bundle hash=", o.Hash)
+  	case outBroadcast:
+  		return superviseOutgoing(conf, o, ts)
+  	case outFunded:
+  		//waits for the signed bundle from sendrawtransaction.
+  	}
+  	return nil
+  }
+
+  //superviseOutgoing marks o as confirmed if one of its attachments is confirmed,
+  //or reattaches it with new tips if it is not confirmed for conf.ReattachAfter.
+  func superviseOutgoing(conf *Conf, o *outgoing, ts []gadk.Transaction) error {
+  	if len(o.Tails) == 0 {
+  		//broadcasted by older versions
+  		o.Tails = []gadk.Trytes{ts[0].Hash()}
+  		o.Attached = o.Updated
+  	}
+  	ni, err := conf.api.GetNodeInfo()
+  	if err != nil {
+  		return err
+  	}
+  	inc, err := conf.api.GetInclusionStates(o.Tails, []gadk.Trytes{ni.LatestMilestone})
+  	if err != nil {
+  		return err
+  	}
+  	for _, included := range inc.States {
+  		if included {
+  			return setOutgoingState(o, outConfirmed, nil)
+  		}
+  	}
+  	if conf.ReattachAfter <= 0 || time.Since(o.Attached) < conf.ReattachAfter {
+  		return nil
+  	}
+  	log.Println("reattaching", o.Hash)
+  	err = PowTrytes(conf.api, gadk.Depth, ts, conf.mwm(), conf.pow)
+  	if err == nil {
+  		err = broadcast(conf.api, ts)
+  	}
+  	if err != nil {
+  		return setOutgoingState(o, o.State, err)
+  	}
+  	o.Raw = encodeRaw(gadk.Bundle(ts))
+  	o.Tails = append(o.Tails, ts[0].Hash())
+  	o.Attached = time.Now()
+  	log.Println("reattached", o.Hash, "new tail=", ts[0].Hash())
+  	return setOutgoingState(o, o.State, nil)
+  }
+
+  //broadcastOutgoing broadcasts o if it is still pow-done. stateMutex must be locked.
+  func broadcastOutgoing(conf *Conf, o *outgoing, ts []gadk.Transaction) error {
+  	var cur *outgoing
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		var err error
+  		cur, err = getOutgoing(tx, o.Hash)
+  		return err
+  	}); err != nil {
+  		return err
+  	}
+  	if cur == nil || cur.State != outPowDone {
+  		return nil
+  	}
+  	if err := broadcast(conf.api, ts); err != nil {
+  		return setOutgoingState(o, o.State, err)
+  	}
+  	o.Tails = append(o.Tails, ts[0].Hash())
+  	o.Attached = time.Now()
+  	return setOutgoingState(o, outBroadcast, nil)
+  }
+
+  //setOutgoingState stores o with the state. If err is not nil, it is recorded as an failed attempt.
+  //Nothing is stored if the state in the DB has been changed (i.e. abandoned) after o was read.
+  func setOutgoingState(o *outgoing, state string, err error) error {
+  	prev := o.State
+  	o.State = state
+  	o.Error = ""
+  	if err != nil {
+  		o.Attempts++
+  		o.Error = err.Error()
+  	}
+  	errr := db.Update(func(tx *bolt.Tx) error {
+  		cur, err := getOutgoing(tx, o.Hash)
+  		if err != nil {
+  			return err
+  		}
+  		if cur == nil || cur.State != prev {
+  			return nil
+  		}
+  		return putOutgoing(tx, o)
+  	})
+  	if errr != nil {
+  		return errr
+  	}
+  	return err
+  }
+
+  //abandonOutgoing marks the bundle as failed and gives its inputs back to the account.
+  //Bundles which may have been broadcasted cannot be abandoned.
+  func abandonOutgoing(hash gadk.Trytes) error {
+  	stateMutex.Lock()
+  	defer stateMutex.Unlock()
+  	return db.Update(func(tx *bolt.Tx) error {
+  		o, err := getOutgoing(tx, hash)
+  		if err != nil {
+  			return err
+  		}
+  		if o == nil {
+  			return errTxidNotFound
+  		}
+  		if o.State != outFunded && o.State != outPrepared && o.State != outPowDone {
+  			return newErr(RPCInvalidAddressOrKey, "Transaction not eligible for abandonment")
+  		}
+  		bd, err := decodeRaw(string(o.Raw))
+  		if err != nil {
+  			return err
+  		}
+  		//read the account from the DB, not the cached one selected by account_no.
+  		var ac *Account
+  		for _, t := range bd {
+  			if t.Value >= 0 {
+  				continue
+  			}
+  			if ac, _, err = findAddress(tx, t.Address); err != nil {
+  				return err
+  			}
+  			if ac != nil {
+  				break
+  			}
+  		}
+  		if ac != nil {
+  			for _, t := range bd {
+  				i := ac.search(t.Address)
+  				if i < 0 {
+  					continue
+  				}
+  				switch {
+  				case t.Value < 0:
+  					ac.Balances[i].Value -= t.Value
+  					ac.Balances[i].Spent += t.Value
+  					if ac.Balances[i].Spent < 0 {
+  						ac.Balances[i].Spent = 0
+  					}
+  				case t.Value > 0:
+  					ac.Balances[i].Change -= t.Value
+  					if ac.Balances[i].Change < 0 {
+  						ac.Balances[i].Change = 0
+  					}
+  				}
+  			}
+  			if err = putAccount(tx, ac); err != nil {
+  				return err
+  			}
+  		}
+  		o.State = outFailed
+  		o.Error = "abandoned"
+  		return putOutgoing(tx, o)
+  	})
+  }
+
+  type listpendingtransfersParams struct {
+  	IncludeAll bool `param:"include_all"`
+  }
+
+  type pendingtransfer struct {
+  	TxID     gadk.Trytes `json:"txid"`
+  	Account  string      `json:"account"`
+  	Amount   Amount      `json:"amount"`
+  	State    string      `json:"state"`
+  	Attempts int         `json:"attempts"`
+  	Error    string      `json:"error,omitempty"`
+  	Tails    []string    `json:"tails"`
+  	Payout   gadk.Trytes `json:"payout,omitempty"`
+  	Time     int64       `json:"time"`
+  	Updated  int64       `json:"updated"`
+  }
+
+  //listpendingtransfers lists bundles in the outbox which are not confirmed nor failed,
+  //or all bundles if include_all is true.
+  func listpendingtransfers(conf *Conf, req *Request, res *Response) error {
+  	var p listpendingtransfersParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	var outs []*outgoing
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		var err error
+  		outs, err = listOutgoing(tx, p.IncludeAll)
+  		return err
+  	}); err != nil {
+  		return err
+  	}
+  	pts := make([]*pendingtransfer, 0, len(outs))
+  	for _, o := range outs {
+  		tails := make([]string, len(o.Tails))
+  		for i, t := range o.Tails {
+  			tails[i] = string(t)
+  		}
+  		pts = append(pts, &pendingtransfer{
+  			TxID:     o.Hash,
+  			Account:  o.Account,
+  			Amount:   -Amount(o.Amount),
+  			State:    o.State,
+  			Attempts: o.Attempts,
+  			Error:    o.Error,
+  			Tails:    tails,
+  			Payout:   o.Payout,
+  			Time:     o.Created.Unix(),
+  			Updated:  o.Updated.Unix(),
+  		})
+  	}
+  	res.Result = pts
+  	return nil
+  }
+
+  type abandontransactionParams struct {
+  	TxID string `param:"txid,required"`
+  }
+
+  func abandontransaction(conf *Conf, req *Request, res *Response) error {
+  	var p abandontransactionParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	mutex.Lock()
+  	defer mutex.Unlock()
+  	return abandonOutgoing(gadk.Trytes(p.TxID))
+  }
@@ -0,0 +1,318 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+
+  package aidos
+
+  import (
+  	"encoding/json"
+  	"errors"
+  	"sync"
+  	"testing"
+  	"time"
+
+  	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
+  )
+
+  func TestOutbox(t *testing.T) {
+  	conf := prepareTest(t)
+  	d1 := newdummy(nil, t)
+  	conf.api = d1
+  	adr1 := gadk.Address("A" + gadk.EmptyAddress[1:])
+  	adr2 := gadk.Address("B" + gadk.EmptyAddress[1:])
+  	adr3 := gadk.Address("C" + gadk.EmptyAddress[1:])
+  	ac := &Account{
+  		Name: "ac1",
+  		Balances: []Balance{
+  			{Balance: gadk.Balance{Address: adr1}},
+  			{Balance: gadk.Balance{Address: adr2}, Change: 40},
+  		},
+  	}
+  	var bd gadk.Bundle
+  	bd.Add(1, adr3, 60, time.Now(), gadk.EmptyHash)
+  	bd.Add(2, adr1, -100, time.Now(), gadk.EmptyHash)
+  	bd.Add(1, adr2, 40, time.Now(), gadk.EmptyHash)
+  	bd.Finalize(nil)
+  	//PoW of unsigned bundles fails, so a bundle without inputs is broadcasted.
+  	var bd0 gadk.Bundle
+  	bd0.Add(1, adr3, 0, time.Now(), gadk.EmptyHash)
+  	bd0.Finalize(nil)
+  	out := func(hash gadk.Trytes) *outgoing {
+  		b := bd
+  		if hash == "BROADCASTED" {
+  			b = bd0
+  		}
+  		return &outgoing{
+  			Hash:    hash,
+  			Account: "ac1",
+  			Amount:  60,
+  			Raw:     encodeRaw(b),
+  			State:   outPrepared,
+  			Created: time.Now(),
+  		}
+  	}
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		if err := putAccount(tx, ac); err != nil {
+  			return err
+  		}
+  		if err := putOutgoing(tx, out("ABANDONED")); err != nil {
+  			return err
+  		}
+  		return putOutgoing(tx, out("BROADCASTED"))
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+
+  	w := post(t, conf, `{"jsonrpc":"1.0","id":1,"method":"listpendingtransfers","params":[]}`)
+  	var res struct {
+  		Result []pendingtransfer
+  		Error  *Err
+  	}
+  	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+  		t.Fatal(err)
+  	}
+  	if res.Error != nil || len(res.Result) != 2 || res.Result[0].State != outPrepared {
+  		t.Fatal("invalid pending transfers", res)
+  	}
+
+  	//abandon with the account selected by account_no.
+  	globalAccountNo = 0
+  	defer func() {
+  		globalAccountNo = -1
+  	}()
+  	if err := abandonOutgoing("ABANDONED"); err != nil {
+  		t.Fatal(err)
+  	}
+  	if err := abandonOutgoing("ABANDONED"); err == nil {
+  		t.Error("abandoned transfer should not be abandoned again")
+  	}
+  	if err := abandonOutgoing("NOTEXIST"); err != errTxidNotFound {
+  		t.Error("unknown transfer should be error", err)
+  	}
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		ac2, err := getAccount(tx, "ac1")
+  		if err != nil {
+  			return err
+  		}
+  		if ac2.Balances[0].Value != 100 || ac2.Balances[1].Change != 0 {
+  			t.Error("balances should be given back", ac2.Balances)
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+
+  	//a state changed by abandoning must not be overwritten.
+  	o := out("ABANDONED")
+  	if err := setOutgoingState(o, outPowDone, nil); err != nil {
+  		t.Fatal(err)
+  	}
+
+  	go func() {
+  		<-d1.ch
+  	}()
+  	processOutbox(conf)
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		o1, err := getOutgoing(tx, "ABANDONED")
+  		if err != nil {
+  			return err
+  		}
+  		if o1.State != outFailed {
+  			t.Error("abandoned transfer should not be processed", o1.State)
+  		}
+  		o2, err := getOutgoing(tx, "BROADCASTED")
+  		if err != nil {
+  			return err
+  		}
+  		if o2.State != outBroadcast || o2.Attempts != 0 {
+  			t.Error("transfer should be broadcasted", o2.State, o2.Error)
+  		}
+  		pow, err := decodeRaw(string(o2.Raw))
+  		if err != nil {
+  			return err
+  		}
+  		for _, tx := range pow {
+  			if !HasValidNonce(&tx, int(conf.mwm())) {
+  				t.Error("invalid nonce")
+  			}
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	if len(d1.broadcasted) != len(bd0) {
+  		t.Error("invalid broadcasted bundle")
+  	}
+  	if err := abandonOutgoing("BROADCASTED"); err == nil {
+  		t.Error("broadcasted transfer should not be abandoned")
+  	}
+
+  	//failed attempts are recorded.
+  	o = out("BROADCASTED")
+  	o.State = outBroadcast
+  	if err := setOutgoingState(o, outBroadcast, errors.New("dummy")); err == nil {
+  		t.Error("should return the error")
+  	}
+  	if o.Attempts != 1 || o.Error != "dummy" {
+  		t.Error("failed attempt should be recorded", o)
+  	}
+  }
+
+  func TestReattach(t *testing.T) {
+  	conf := prepareTest(t)
+  	d1 := newdummy(nil, t)
+  	conf.api = d1
+  	conf.ReattachAfter = time.Minute
+  	var bd gadk.Bundle
+  	bd.Add(1, gadk.Address("C"+gadk.EmptyAddress[1:]), 0, time.Now(), gadk.EmptyHash)
+  	bd.Finalize(nil)
+  	o := &outgoing{
+  		Hash:     "REATTACHED",
+  		Account:  "ac1",
+  		Raw:      encodeRaw(bd),
+  		State:    outBroadcast,
+  		Tails:    []gadk.Trytes{"TAIL"},
+  		Attached: time.Now(),
+  		Created:  time.Now(),
+  	}
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		return putOutgoing(tx, o)
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	get := func() *outgoing {
+  		var o2 *outgoing
+  		if err := db.View(func(tx *bolt.Tx) error {
+  			var err error
+  			o2, err = getOutgoing(tx, "REATTACHED")
+  			return err
+  		}); err != nil {
+  			t.Fatal(err)
+  		}
+  		return o2
+  	}
+
+  	//too young to be reattached.
+  	processOutbox(conf)
+  	if o2 := get(); o2.State != outBroadcast || len(o2.Tails) != 1 {
+  		t.Error("transfer should not be reattached", o2)
+  	}
+
+  	o.Attached = time.Now().Add(-2 * time.Minute)
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		return putOutgoing(tx, o)
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	go func() {
+  		<-d1.ch
+  	}()
+  	processOutbox(conf)
+  	o2 := get()
+  	if o2.State != outBroadcast || len(o2.Tails) != 2 || o2.Tails[0] != "TAIL" {
+  		t.Error("transfer should be reattached", o2)
+  	}
+  	if time.Since(o2.Attached) > time.Minute {
+  		t.Error("attached time should be updated", o2.Attached)
+  	}
+  	if len(d1.broadcasted) != len(bd) {
+  		t.Error("invalid broadcasted bundle")
+  	}
+
+  	d1.isConf = true
+  	processOutbox(conf)
+  	if o2 := get(); o2.State != outConfirmed {
+  		t.Error("transfer should be confirmed", o2.State)
+  	}
+  }
+
+  func TestParallelOutbox(t *testing.T) {
+  	conf := prepareTest(t)
+  	d1 := newdummy(nil, t)
+  	conf.api = d1
+  	conf.outboxSlots = make(chan struct{}, 2)
+  	var mutex sync.Mutex
+  	var running, max int
+  	p := newPoolPow(4)
+  	pow := p.pow
+  	p.pow = func(tr gadk.Trytes, mwm int) (gadk.Trytes, error) {
+  		mutex.Lock()
+  		running++
+  		if running > max {
+  			max = running
+  		}
+  		mutex.Unlock()
+  		time.Sleep(50 * time.Millisecond)
+  		mutex.Lock()
+  		running--
+  		mutex.Unlock()
+  		return pow(tr, mwm)
+  	}
+  	conf.pow = &powStats{PowProvider: p}
+
+  	hashes := []gadk.Trytes{"A", "B", "C", "D"}
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		for i, h := range hashes {
+  			var bd gadk.Bundle
+  			bd.Add(1, gadk.Address(h)+gadk.EmptyAddress[1:], 0, time.Now(), gadk.EmptyHash)
+  			bd.Finalize(nil)
+  			o := &outgoing{
+  				Hash:    h,
+  				Account: "ac1",
+  				Raw:     encodeRaw(bd),
+  				State:   outPrepared,
+  				Created: time.Now().Add(time.Duration(i) * time.Second),
+  			}
+  			if err := putOutgoing(tx, o); err != nil {
+  				return err
+  			}
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	go func() {
+  		for range hashes {
+  			<-d1.ch
+  		}
+  	}()
+  	processOutbox(conf)
+  	if max != 2 {
+  		t.Error("PoW of 2 bundles should run concurrently", max)
+  	}
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		for _, h := range hashes {
+  			o, err := getOutgoing(tx, h)
+  			if err != nil {
+  				return err
+  			}
+  			if o.State != outBroadcast {
+  				t.Error("transfer should be broadcasted", h, o.State, o.Error)
+  			}
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	if len(processing) != 0 {
+  		t.Error("processing should be empty", processing)
+  	}
+  }
@@ -0,0 +1,167 @@
+  // Copyright (c) 2017 Aidos Developer
+
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+
+  package aidos
+
+  import (
+  	"encoding/json"
+  	"math"
+  	"reflect"
+  	"strings"
+  )
+
+  //parseParams parses positional (array) or named (object) params of req into v,
+  //which must be a pointer to a struct.
+  //Positional params are set to the fields in order, and named ones are set to
+  //the fields whose `param` tag has the name. Names in a tag are separated by '|',
+  //and the option ",required" means the param must not be omitted.
+  //Numbers are parsed as json.Number, and fields of Amount are set exactly by toAmount.
+  //Omitted or null params don't change the fields, so values in v are used as defaults.
+  func parseParams(req *Request, v interface{}) error {
+  	rv := reflect.ValueOf(v).Elem()
+  	rt := rv.Type()
+  	set := make([]bool, rt.NumField())
+  	switch ps := req.Params.(type) {
+  	case nil:
+  	case []interface{}:
+  		if len(ps) > rt.NumField() {
+  			return newErr(RPCInvalidParams, "too many params")
+  		}
+  		for i, p := range ps {
+  			ok, err := setParam(rv.Field(i), rt.Field(i), p)
+  			if err != nil {
+  				return err
+  			}
+  			set[i] = ok
+  		}
+  	case map[string]interface{}:
+  		for name, p := range ps {
+  			i := paramIndex(rt, name)
+  			if i < 0 {
+  				return newErr(RPCInvalidParams, "unknown param "+name)
+  			}
+  			ok, err := setParam(rv.Field(i), rt.Field(i), p)
+  			if err != nil {
+  				return err
+  			}
+  			set[i] = ok
+  		}
+  	default:
+  		return newErr(RPCInvalidParams, "params must be an array or an object")
+  	}
+  	for i := 0; i < rt.NumField(); i++ {
+  		names, required := paramTag(rt.Field(i))
+  		if required && !set[i] {
+  			return newErr(RPCInvalidParams, "missing param "+names[0])
+  		}
+  	}
+  	return nil
+  }
+
+  func paramTag(f reflect.StructField) ([]string, bool) {
+  	tag := f.Tag.Get("param")
+  	required := false
+  	if i := strings.Index(tag, ","); i >= 0 {
+  		required = tag[i+1:] == "required"
+  		tag = tag[:i]
+  	}
+  	return strings.Split(tag, "|"), required
+  }
+
+  func paramIndex(rt reflect.Type, name string) int {
+  	for i := 0; i < rt.NumField(); i++ {
+  		names, _ := paramTag(rt.Field(i))
+  		for _, n := range names {
+  			if n == name {
+  				return i
+  			}
+  		}
+  	}
+  	return -1
+  }
+
+  //setParam sets p to v after checking its type, and returns true if p is not null.
+  func setParam(v reflect.Value, f reflect.StructField, p interface{}) (bool, error) {
+  	if p == nil {
+  		return false, nil
+  	}
+  	names, _ := paramTag(f)
+  	errInvalid := newErr(RPCInvalidParams, "invalid "+names[0])
+  	if v.Type() == reflect.TypeOf(Amount(0)) {
+  		a, err := toAmount(p)
+  		if err != nil {
+  			return false, err
+  		}
+  		v.SetInt(int64(a))
+  		return true, nil
+  	}
+  	switch v.Kind() {
+  	case reflect.String:
+  		s, ok := p.(string)
+  		if !ok {
+  			return false, errInvalid
+  		}
+  		v.SetString(s)
+  	case reflect.Bool:
+  		b, ok := p.(bool)
+  		if !ok {
+  			return false, errInvalid
+  		}
+  		v.SetBool(b)
+  	case reflect.Int, reflect.Int64:
+  		n, ok := toFloat(p)
+  		if !ok || n != math.Trunc(n) {
+  			return false, errInvalid
+  		}
+  		v.SetInt(int64(n))
+  	case reflect.Float64:
+  		n, ok := toFloat(p)
+  		if !ok {
+  			return false, errInvalid
+  		}
+  		v.SetFloat(n)
+  	case reflect.Map:
+  		m, ok := p.(map[string]interface{})
+  		if !ok {
+  			return false, errInvalid
+  		}
+  		v.Set(reflect.ValueOf(m))
+  	case reflect.Interface:
+  		v.Set(reflect.ValueOf(p))
+  	default:
+  		panic("unsupported type of param " + f.Name)
+  	}
+  	return true, nil
+  }
+
+  func toFloat(p interface{}) (float64, bool) {
+  	switch n := p.(type) {
+  	case json.Number:
+  		f, err := n.Float64()
+  		return f, err == nil
+  	case float64:
+  		return n, true
+  	case int:
+  		return float64(n), true
+  	case int64:
+  		return float64(n), true
+  	}
+  	return 0, false
+  }
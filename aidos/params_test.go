@@ -0,0 +1,81 @@
+  // Copyright (c) 2017 Aidos Developer
+
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+
+  package aidos
+
+  import (
+  	"encoding/json"
+  	"testing"
+  )
+
+  type testParams struct {
+  	Account string  `param:"account|label,required"`
+  	Count   int     `param:"count"`
+  	Amount  float64 `param:"amount"`
+  	Watch   bool    `param:"include_watchonly"`
+  }
+
+  func TestParseParams(t *testing.T) {
+  	cases := []struct {
+  		params string
+  		want   testParams
+  		err    bool
+  	}{
+  		{`["ac1"]`, testParams{"ac1", 10, 0, false}, false},
+  		{`["ac1", 3, 0.5, true]`, testParams{"ac1", 3, 0.5, true}, false},
+  		{`["ac1", null, 0.5]`, testParams{"ac1", 10, 0.5, false}, false},
+  		{`{"account":"ac1","count":3}`, testParams{"ac1", 3, 0, false}, false},
+  		{`{"label":"ac1","include_watchonly":true}`, testParams{"ac1", 10, 0, true}, false},
+  		{`{"count":3}`, testParams{}, true},
+  		{`[]`, testParams{}, true},
+  		{`null`, testParams{}, true},
+  		{`["ac1", 3, 0.5, true, 1]`, testParams{}, true},
+  		{`{"account":"ac1","nosuch":1}`, testParams{}, true},
+  		{`["ac1", 1.5]`, testParams{}, true},
+  		{`["ac1", "3"]`, testParams{}, true},
+  		{`[1]`, testParams{}, true},
+  		{`"ac1"`, testParams{}, true},
+  	}
+  	for i, c := range cases {
+  		var req Request
+  		if err := json.Unmarshal([]byte(`{"method":"test","params":`+c.params+`}`), &req); err != nil {
+  			t.Fatal(err)
+  		}
+  		p := testParams{Count: 10}
+  		err := parseParams(&req, &p)
+  		if c.err {
+  			if err == nil {
+  				t.Error(i, "should be error", c.params)
+  				continue
+  			}
+  			if e, ok := err.(*Err); !ok || e.Code != RPCInvalidParams {
+  				t.Error(i, "invalid error", err)
+  			}
+  			continue
+  		}
+  		if err != nil {
+  			t.Error(i, err)
+  			continue
+  		}
+  		if p != c.want {
+  			t.Error(i, "invalid params", p, "should be", c.want)
+  		}
+  	}
+  }
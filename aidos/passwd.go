@@ -19,87 +19,364 @@
   // THE SOFTWARE.
   
   package aidos
-  
   import (
   	"bytes"
   	"crypto/aes"
   	"crypto/cipher"
   	"crypto/rand"
   	"crypto/sha256"
+  	"crypto/subtle"
+  	"encoding/json"
   	"errors"
   	"io"
-  
+
   	"github.com/boltdb-go/bolt"
+  	"golang.org/x/crypto/scrypt"
   )
-  
+
   var (
-  	passPhrase = []byte("AidosKuneen") // Phrase that is encrypted/decrypted using a password
-  	block      *aesCrypto
-  	passDB     = []byte("pass_phrase") // Bucket name (in Bolt) for the encrypted passPhrase
+  	passPhrase  = []byte("AidosKuneen") // Phrase that was encrypted with a legacy key to check a password
+  	block       *aesCrypto
+  	passDB      = []byte("pass_phrase") // Bucket name (in Bolt) for the password verifier
+  	verifierKey = []byte("verifier")    // Key for the KDF parameters and the password verifier
   )
-  
+
+  //Parameters of scrypt for new passwords. Parameters for existing passwords
+  //are stored in the DB, so these can be changed without breaking wallets.
+  var (
+  	scryptN = 1 << 15
+  	scryptR = 8
+  	scryptP = 1
+  )
+
+  //Ciphertexts of seeds are enveloped with magic bytes and a version:
+  //	magic(2 bytes) | version(1 byte) | nonce | sealed seed
+  //Legacy ciphertexts (AES-CTR with SHA256 of the password, no envelope) can be still decrypted.
+  var envelopeMagic = []byte("AK")
+
+  const (
+  	envelopeV1 = 1 // AES-256-GCM with a key derived by scrypt
+  )
+
+  var (
+  	errIncorrectPassword = errors.New("incorrect password")
+  	errEmptyPassword     = errors.New("password must not be empty")
+  	errEncrypted         = errors.New("wallet is already encrypted")
+  	errNotEncrypted      = errors.New("wallet is not encrypted")
+  )
+
+  //kdf is a salt and parameters of scrypt, and the verifier of the password.
+  type kdf struct {
+  	Salt     []byte
+  	N        int
+  	R        int
+  	P        int
+  	Verifier []byte
+  }
+
+  func newKDF() (*kdf, error) {
+  	k := &kdf{
+  		Salt: make([]byte, 32),
+  		N:    scryptN,
+  		R:    scryptR,
+  		P:    scryptP,
+  	}
+  	if _, err := io.ReadFull(rand.Reader, k.Salt); err != nil {
+  		return nil, err
+  	}
+  	return k, nil
+  }
+
+  //derive derives an encryption key and a verifier from pwd.
+  //The verifier is a hash of another half of derived bytes, so the key cannot be known from it.
+  func (k *kdf) derive(pwd []byte) ([]byte, []byte, error) {
+  	dk, err := scrypt.Key(pwd, k.Salt, k.N, k.R, k.P, 64)
+  	if err != nil {
+  		return nil, nil, err
+  	}
+  	v := sha256.Sum256(dk[32:])
+  	return dk[:32], v[:], nil
+  }
+
   type aesCrypto struct {
-  	block  cipher.Block
-  	pwd256 []byte
+  	aead   cipher.AEAD
+  	legacy cipher.Block
+  	kdf    *kdf
   }
-  
-  func newAESCrpto(pwd []byte) (*aesCrypto, error) {
-  	pwd2 := sha256.Sum256(pwd)
-  	pwd256 := pwd2[:]
-  	block, err := aes.NewCipher(pwd256)
+
+  //newAESCrpto derives a key from pwd with k. If k doesn't have a verifier yet,
+  //it is set, or pwd is checked with it.
+  func newAESCrpto(pwd []byte, k *kdf) (*aesCrypto, error) {
+  	key, v, err := k.derive(pwd)
+  	if err != nil {
+  		return nil, err
+  	}
+  	if k.Verifier == nil {
+  		k.Verifier = v
+  	}
+  	if subtle.ConstantTimeCompare(v, k.Verifier) != 1 {
+  		return nil, errIncorrectPassword
+  	}
+  	blk, err := aes.NewCipher(key)
+  	if err != nil {
+  		return nil, err
+  	}
+  	aead, err := cipher.NewGCM(blk)
+  	if err != nil {
+  		return nil, err
+  	}
+  	pwd256 := sha256.Sum256(pwd)
+  	legacy, err := aes.NewCipher(pwd256[:])
   	if err != nil {
   		return nil, err
   	}
   	return &aesCrypto{
-  		block:  block,
-  		pwd256: pwd256,
+  		aead:   aead,
+  		legacy: legacy,
+  		kdf:    k,
   	}, nil
   }
-  
+
+  //verify returns true if pwd is the password.
+  func (a *aesCrypto) verify(pwd []byte) bool {
+  	_, v, err := a.kdf.derive(pwd)
+  	return err == nil && subtle.ConstantTimeCompare(v, a.kdf.Verifier) == 1
+  }
+
   func (a *aesCrypto) encrypt(pt []byte) []byte {
-  	ct := make([]byte, aes.BlockSize+len(pt))
-  	iv := ct[:aes.BlockSize]
-  	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+  	nonce := make([]byte, a.aead.NonceSize())
+  	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
   		panic(err)
   	}
-  	encryptStream := cipher.NewCTR(a.block, iv)
-  	encryptStream.XORKeyStream(ct[aes.BlockSize:], pt)
-  	return ct
+  	ct := make([]byte, 0, len(envelopeMagic)+1+len(nonce)+len(pt)+a.aead.Overhead())
+  	ct = append(ct, envelopeMagic...)
+  	ct = append(ct, envelopeV1)
+  	ct = append(ct, nonce...)
+  	return a.aead.Seal(ct, nonce, pt, nil)
   }
-  
-  func (a *aesCrypto) decrypt(ct []byte) []byte {
+
+  func isEnveloped(ct []byte) bool {
+  	return len(ct) > len(envelopeMagic) && bytes.HasPrefix(ct, envelopeMagic) &&
+  		ct[len(envelopeMagic)] == envelopeV1
+  }
+
+  func (a *aesCrypto) decrypt(ct []byte) ([]byte, error) {
+  	if !isEnveloped(ct) {
+  		return a.decryptLegacy(ct)
+  	}
+  	body := ct[len(envelopeMagic)+1:]
+  	if len(body) < a.aead.NonceSize() {
+  		return nil, errors.New("ciphertext is too short")
+  	}
+  	ns := a.aead.NonceSize()
+  	return a.aead.Open(nil, body[:ns], body[ns:], nil)
+  }
+
+  func (a *aesCrypto) decryptLegacy(ct []byte) ([]byte, error) {
+  	if len(ct) < aes.BlockSize {
+  		return nil, errors.New("ciphertext is too short")
+  	}
   	pt := make([]byte, len(ct[aes.BlockSize:]))
-  	decryptStream := cipher.NewCTR(a.block, ct[:aes.BlockSize])
+  	decryptStream := cipher.NewCTR(a.legacy, ct[:aes.BlockSize])
   	decryptStream.XORKeyStream(pt, ct[aes.BlockSize:])
-  	return pt
+  	return pt, nil
   }
-  
-  //Password gets a password, encrypt the defined passPhrase with it and saves the result in Bolt. When the Bolt bucket
-  //bucket is available, it checks that the content can be decrypted using the provided password.
-  func password(pwd []byte) error {
-  	var err error
-  	block, err = newAESCrpto(pwd)
+
+  func wipe(b []byte) {
+  	for i := range b {
+  		b[i] = 0
+  	}
+  }
+
+  //reencryptSeeds decrypts seeds of all accounts with from and encrypt them with to.
+  //If legacyOnly, only seeds in the legacy format are re-encrypted.
+  func reencryptSeeds(tx *bolt.Tx, from, to *aesCrypto, legacyOnly bool) error {
+  	b := tx.Bucket(accountDB)
+  	if b == nil {
+  		return nil
+  	}
+  	type kv struct {
+  		k, v []byte
+  	}
+  	var kvs []kv
+  	err := b.ForEach(func(k, v []byte) error {
+  		var ac Account
+  		if err := json.Unmarshal(v, &ac); err != nil {
+  			return err
+  		}
+  		if legacyOnly && isEnveloped(ac.EncSeed) {
+  			return nil
+  		}
+  		seed, err := from.decrypt(ac.EncSeed)
+  		if err != nil {
+  			return err
+  		}
+  		ac.EncSeed = to.encrypt(seed)
+  		wipe(seed)
+  		bin, err := json.Marshal(&ac)
+  		if err != nil {
+  			return err
+  		}
+  		kvs = append(kvs, kv{append([]byte{}, k...), bin})
+  		return nil
+  	})
   	if err != nil {
-  		panic(err)
+  		return err
   	}
-  	err = db.Update(func(tx *bolt.Tx) error {
-  		var errr error
-  		b := tx.Bucket(passDB)
-  		if b == nil {
-  			cipherText := block.encrypt(passPhrase)
-  			b, errr = tx.CreateBucket(passDB)
-  			if errr != nil {
-  				return errr
+  	for _, e := range kvs {
+  		if err := b.Put(e.k, e.v); err != nil {
+  			return err
+  		}
+  	}
+  	return nil
+  }
+
+  //Password checks the password with the verifier in Bolt, or sets up the verifier if not exists.
+  //Wallets with a legacy passphrase (i.e. SHA256 of the password and AES-CTR) are upgraded to
+  //scrypt and AES-GCM here.
+  func password(pwd []byte) error {
+  	var a *aesCrypto
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		k, err := getKDF(tx)
+  		if err != nil {
+  			return err
+  		}
+  		if k != nil {
+  			if a, err = newAESCrpto(pwd, k); err != nil {
+  				return err
   			}
-  			return b.Put(passDB, cipherText)
+  			return reencryptSeeds(tx, a, a, true)
+  		}
+  		a, err = encryptWallet(tx, pwd)
+  		return err
+  	})
+  	if err != nil {
+  		return err
+  	}
+  	block = a
+  	return nil
+  }
+
+  //encryptWallet sets up the verifier for pwd and encrypts seeds with it.
+  //If the wallet has a legacy passphrase, pwd must match it.
+  func encryptWallet(tx *bolt.Tx, pwd []byte) (*aesCrypto, error) {
+  	b, err := tx.CreateBucketIfNotExists(passDB)
+  	if err != nil {
+  		return nil, err
+  	}
+  	k, err := newKDF()
+  	if err != nil {
+  		return nil, err
+  	}
+  	a, err := newAESCrpto(pwd, k)
+  	if err != nil {
+  		return nil, err
+  	}
+  	if ct := b.Get(passDB); ct != nil {
+  		pt, err := a.decryptLegacy(ct)
+  		if err != nil {
+  			return nil, err
   		}
-  		ct := b.Get(passDB)
-  		pt := block.decrypt(ct)
   		if !bytes.Equal(passPhrase, pt) {
-  			return errors.New("incorrect password")
+  			return nil, errIncorrectPassword
   		}
-  		return nil
+  		if err = b.Delete(passDB); err != nil {
+  			return nil, err
+  		}
+  	}
+  	if err = putKDF(tx, k); err != nil {
+  		return nil, err
+  	}
+  	return a, reencryptSeeds(tx, a, a, true)
+  }
+
+  //EncryptWallet encrypts the wallet with pwd if it has not been encrypted yet.
+  func EncryptWallet(pwd []byte) error {
+  	if len(pwd) == 0 {
+  		return errEmptyPassword
+  	}
+  	var a *aesCrypto
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		k, err := getKDF(tx)
+  		if err != nil {
+  			return err
+  		}
+  		if k != nil {
+  			return errEncrypted
+  		}
+  		a, err = encryptWallet(tx, pwd)
+  		return err
+  	})
+  	if err != nil {
+  		return err
+  	}
+  	block = a
+  	return nil
+  }
+
+  //ChangePassword re-encrypts all seeds and the verifier with newpwd in one transaction,
+  //and reloads cached accounts in it. Callers must hold mutex while aidosd is serving.
+  func ChangePassword(oldpwd, newpwd []byte) error {
+  	if len(newpwd) == 0 {
+  		return errEmptyPassword
+  	}
+  	var to *aesCrypto
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		k, err := getKDF(tx)
+  		if err != nil {
+  			return err
+  		}
+  		if k == nil {
+  			return errNotEncrypted
+  		}
+  		from, err := newAESCrpto(oldpwd, k)
+  		if err != nil {
+  			return err
+  		}
+  		nk, err := newKDF()
+  		if err != nil {
+  			return err
+  		}
+  		if to, err = newAESCrpto(newpwd, nk); err != nil {
+  			return err
+  		}
+  		if err = reencryptSeeds(tx, from, to, false); err != nil {
+  			return err
+  		}
+  		return putKDF(tx, nk)
   	})
-  	return err
+  	if err != nil {
+  		return err
+  	}
+  	block = to
+  	return nil
+  }
+
+  //getKDF returns the KDF parameters and the verifier, or nil if the wallet is not encrypted.
+  func getKDF(tx *bolt.Tx) (*kdf, error) {
+  	b := tx.Bucket(passDB)
+  	if b == nil {
+  		return nil, nil
+  	}
+  	v := b.Get(verifierKey)
+  	if v == nil {
+  		return nil, nil
+  	}
+  	var k kdf
+  	if err := json.Unmarshal(v, &k); err != nil {
+  		return nil, err
+  	}
+  	return &k, nil
+  }
+
+  func putKDF(tx *bolt.Tx, k *kdf) error {
+  	b, err := tx.CreateBucketIfNotExists(passDB)
+  	if err != nil {
+  		return err
+  	}
+  	bin, err := json.Marshal(k)
+  	if err != nil {
+  		return err
+  	}
+  	return b.Put(verifierKey, bin)
   }
-  
\ No newline at end of file
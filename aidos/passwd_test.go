@@ -0,0 +1,240 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+
+  package aidos
+
+  import (
+  	"bytes"
+  	"crypto/aes"
+  	"crypto/cipher"
+  	"crypto/rand"
+  	"crypto/sha256"
+  	"encoding/json"
+  	"testing"
+
+  	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
+  )
+
+  //legacyEncrypt encrypts pt in the same way as old versions did.
+  func legacyEncrypt(t *testing.T, pwd, pt []byte) []byte {
+  	pwd256 := sha256.Sum256(pwd)
+  	blk, err := aes.NewCipher(pwd256[:])
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	ct := make([]byte, aes.BlockSize+len(pt))
+  	if _, err = rand.Read(ct[:aes.BlockSize]); err != nil {
+  		t.Fatal(err)
+  	}
+  	cipher.NewCTR(blk, ct[:aes.BlockSize]).XORKeyStream(ct[aes.BlockSize:], pt)
+  	return ct
+  }
+
+  func TestPasswd(t *testing.T) {
+  	prepareTest(t)
+  	seed := gadk.NewSeed()
+
+  	//make a wallet of an old version
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		b := tx.Bucket(passDB)
+  		if err := b.Delete(verifierKey); err != nil {
+  			return err
+  		}
+  		if err := b.Put(passDB, legacyEncrypt(t, []byte("test"), passPhrase)); err != nil {
+  			return err
+  		}
+  		ac := Account{
+  			Name:    "legacy",
+  			EncSeed: legacyEncrypt(t, []byte("test"), []byte(seed)),
+  		}
+  		bin, err := json.Marshal(&ac)
+  		if err != nil {
+  			return err
+  		}
+  		ab, err := tx.CreateBucketIfNotExists(accountDB)
+  		if err != nil {
+  			return err
+  		}
+  		return ab.Put(toKey(ac.Name), bin)
+  	})
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+
+  	if err = password([]byte("wrong")); err != errIncorrectPassword {
+  		t.Error("wrong password must be rejected", err)
+  	}
+  	if err = db.View(func(tx *bolt.Tx) error {
+  		if tx.Bucket(passDB).Get(passDB) == nil {
+  			t.Error("legacy passphrase must remain after a wrong password")
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+
+  	if err = password([]byte("test")); err != nil {
+  		t.Fatal(err)
+  	}
+  	var enc []byte
+  	if err = db.View(func(tx *bolt.Tx) error {
+  		b := tx.Bucket(passDB)
+  		if b.Get(passDB) != nil || b.Get(verifierKey) == nil {
+  			t.Error("passphrase must be upgraded to the verifier")
+  		}
+  		ac, err := getAccount(tx, "legacy")
+  		if err != nil {
+  			return err
+  		}
+  		if err = ac.withSeed(func(s gadk.Trytes) error {
+  			if s != seed {
+  				t.Error("invalid seed after re-encryption")
+  			}
+  			return nil
+  		}); err != nil {
+  			return err
+  		}
+  		if !isEnveloped(ac.EncSeed) {
+  			t.Error("seed must be re-encrypted with the new format")
+  		}
+  		enc = ac.EncSeed
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+
+  	if !block.verify([]byte("test")) || block.verify([]byte("wrong")) {
+  		t.Error("invalid verification")
+  	}
+  	if err = password([]byte("wrong")); err != errIncorrectPassword {
+  		t.Error("wrong password must be rejected", err)
+  	}
+  	if err = password([]byte("test")); err != nil {
+  		t.Error(err)
+  	}
+
+  	pt, err := block.decrypt(enc)
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	if !bytes.Equal(pt, []byte(seed)) {
+  		t.Error("invalid decryption")
+  	}
+  	for _, i := range []int{len(envelopeMagic) + 1, len(enc) - 1} {
+  		tampered := append([]byte{}, enc...)
+  		tampered[i] ^= 1
+  		if _, err = block.decrypt(tampered); err == nil {
+  			t.Error("tampered ciphertext must be rejected", i)
+  		}
+  	}
+  }
+
+  func TestChangePassword(t *testing.T) {
+  	conf := prepareTest(t)
+  	seed := gadk.NewSeed()
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		return putAccount(tx, newAccount("ac1", seed))
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+
+  	w := post(t, conf, `{"jsonrpc":"1.0","id":1,"method":"walletpassphrasechange","params":["wrong","new"]}`)
+  	var res Response
+  	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+  		t.Fatal(err)
+  	}
+  	if res.Error == nil || res.Error.Code != RPCWalletPassphraseIncorrect {
+  		t.Error("wrong password must be rejected", res.Error)
+  	}
+  	w = post(t, conf, `{"jsonrpc":"1.0","id":1,"method":"walletpassphrasechange","params":["test","new"]}`)
+  	res = Response{}
+  	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+  		t.Fatal(err)
+  	}
+  	if res.Error != nil {
+  		t.Fatal(res.Error)
+  	}
+  	w = post(t, conf, `{"jsonrpc":"1.0","id":1,"method":"encryptwallet","params":["new"]}`)
+  	res = Response{}
+  	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+  		t.Fatal(err)
+  	}
+  	if res.Error == nil || res.Error.Code != RPCWalletWrongEncState {
+  		t.Error("encryptwallet must fail for an encrypted wallet", res.Error)
+  	}
+
+  	if err := password([]byte("test")); err != errIncorrectPassword {
+  		t.Error("old password must be rejected", err)
+  	}
+  	if err := password([]byte("new")); err != nil {
+  		t.Fatal(err)
+  	}
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		ac, err := getAccount(tx, "ac1")
+  		if err != nil {
+  			return err
+  		}
+  		return ac.withSeed(func(s gadk.Trytes) error {
+  			if s != seed {
+  				t.Error("invalid seed after changing the password")
+  			}
+  			return nil
+  		})
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	if err := ChangePassword([]byte("new"), nil); err != errEmptyPassword {
+  		t.Error("empty password must be rejected", err)
+  	}
+  }
+
+  func TestEncryptWallet(t *testing.T) {
+  	conf := prepareTest(t)
+  	//make a wallet which has no password yet.
+  	if err := db.Update(func(tx *bolt.Tx) error {
+  		return tx.Bucket(passDB).Delete(verifierKey)
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	w := post(t, conf, `{"jsonrpc":"1.0","id":1,"method":"encryptwallet","params":["new"]}`)
+  	var res Response
+  	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+  		t.Fatal(err)
+  	}
+  	if res.Error != nil || res.Result != "wallet encrypted" {
+  		t.Fatal("wallet must be encrypted", res.Error, res.Result)
+  	}
+  	if err := password([]byte("test")); err != errIncorrectPassword {
+  		t.Error("other password must be rejected", err)
+  	}
+  	if err := password([]byte("new")); err != nil {
+  		t.Fatal(err)
+  	}
+  	w = post(t, conf, `{"jsonrpc":"1.0","id":1,"method":"encryptwallet","params":["new"]}`)
+  	res = Response{}
+  	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+  		t.Fatal(err)
+  	}
+  	if res.Error == nil || res.Error.Code != RPCWalletWrongEncState {
+  		t.Error("encryptwallet must fail for an encrypted wallet", res.Error)
+  	}
+  }
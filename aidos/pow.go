@@ -0,0 +1,271 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+
+  import (
+  	"bytes"
+  	"encoding/json"
+  	"errors"
+  	"fmt"
+  	"net/http"
+  	"runtime"
+  	"sync"
+  	"time"
+
+  	"github.com/AidosKuneen/gadk"
+  )
+
+  //PowProvider does PoW of bundles, i.e. attachToMesh.
+  type PowProvider interface {
+  	//Attach sets trunk, branch and nonce of trytes so that the bundle approves tra.
+  	Attach(tra *gadk.GetTransactionsToApproveResponse, trytes []gadk.Transaction, mwm int64) error
+  	//Info returns the description of the provider.
+  	Info() string
+  }
+
+  //localPow does PoW on this machine. Bundles are not serialized here,
+  //so up to pow_parallel bundles from the outbox run at the same time.
+  type localPow struct {
+  	info string
+  	pow  gadk.PowFunc
+  }
+
+  func newLocalPow() *localPow {
+  	info, pow := gadk.GetBestPoW()
+  	return &localPow{
+  		info: info,
+  		pow:  pow,
+  	}
+  }
+
+  func (l *localPow) Attach(tra *gadk.GetTransactionsToApproveResponse, trytes []gadk.Transaction, mwm int64) error {
+  	return doPow(tra, trytes, mwm, l.pow)
+  }
+
+  func (l *localPow) Info() string {
+  	return "local (" + l.info + ")"
+  }
+
+  //poolPow does PoW with len(workers) cores on this machine. Each worker does PoW of a bundle
+  //with one core, so up to len(workers) bundles are processed at the same time.
+  type poolPow struct {
+  	info    string
+  	pow     gadk.PowFunc
+  	workers chan struct{}
+  }
+
+  //newPoolPow makes a pool of n workers. PoW functions of gadk run with gadk.PowProcs goroutines,
+  //which is set to 1 here so that the pool uses at most n cores.
+  func newPoolPow(n int) *poolPow {
+  	gadk.PowProcs = 1
+  	info, pow := gadk.GetBestPoW()
+  	return &poolPow{
+  		info:    info,
+  		pow:     pow,
+  		workers: make(chan struct{}, n),
+  	}
+  }
+
+  func (p *poolPow) Attach(tra *gadk.GetTransactionsToApproveResponse, trytes []gadk.Transaction, mwm int64) error {
+  	p.workers <- struct{}{}
+  	defer func() {
+  		<-p.workers
+  	}()
+  	return doPow(tra, trytes, mwm, p.pow)
+  }
+
+  func (p *poolPow) Info() string {
+  	return fmt.Sprintf("pool of %d workers (%s)", cap(p.workers), p.info)
+  }
+
+  //remotePow calls attachToTangle API of a node (or a PoW server) which is compatible with IRI.
+  type remotePow struct {
+  	url    string
+  	client *http.Client
+  }
+
+  func newRemotePow(url string) *remotePow {
+  	return &remotePow{
+  		url: url,
+  		client: &http.Client{
+  			Timeout: 30 * time.Minute,
+  		},
+  	}
+  }
+
+  type attachRequest struct {
+  	Command            string        `json:"command"`
+  	TrunkTransaction   gadk.Trytes   `json:"trunkTransaction"`
+  	BranchTransaction  gadk.Trytes   `json:"branchTransaction"`
+  	MinWeightMagnitude int64         `json:"minWeightMagnitude"`
+  	Trytes             []gadk.Trytes `json:"trytes"`
+  }
+
+  type attachResponse struct {
+  	Trytes []gadk.Trytes `json:"trytes"`
+  	Error  string        `json:"error"`
+  }
+
+  //Attach sends trytes from the last tx like IRI, and puts returned txs in order of CurrentIndex.
+  func (r *remotePow) Attach(tra *gadk.GetTransactionsToApproveResponse, trytes []gadk.Transaction, mwm int64) error {
+  	req := &attachRequest{
+  		Command:            "attachToTangle",
+  		TrunkTransaction:   tra.TrunkTransaction,
+  		BranchTransaction:  tra.BranchTransaction,
+  		MinWeightMagnitude: mwm,
+  		Trytes:             make([]gadk.Trytes, len(trytes)),
+  	}
+  	for i := range trytes {
+  		req.Trytes[len(trytes)-1-i] = trytes[i].Trytes()
+  	}
+  	b, err := json.Marshal(req)
+  	if err != nil {
+  		return err
+  	}
+  	hreq, err := http.NewRequest("POST", r.url, bytes.NewBuffer(b))
+  	if err != nil {
+  		return err
+  	}
+  	hreq.Header.Set("Content-Type", "application/json")
+  	hreq.Header.Set("X-IOTA-API-Version", "1")
+  	resp, err := r.client.Do(hreq)
+  	if err != nil {
+  		return err
+  	}
+  	defer resp.Body.Close()
+  	var res attachResponse
+  	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+  		return err
+  	}
+  	if res.Error != "" {
+  		return errors.New(res.Error)
+  	}
+  	if resp.StatusCode != http.StatusOK {
+  		return errors.New("remote PoW failed: " + resp.Status)
+  	}
+  	if len(res.Trytes) != len(trytes) {
+  		return errors.New("remote PoW returned invalid number of txs")
+  	}
+  	done := make([]bool, len(trytes))
+  	for _, t := range res.Trytes {
+  		tx, err := gadk.NewTransaction(t)
+  		if err != nil {
+  			return err
+  		}
+  		if tx.CurrentIndex < 0 || tx.CurrentIndex >= int64(len(trytes)) || done[tx.CurrentIndex] {
+  			return errors.New("remote PoW returned invalid txs")
+  		}
+  		done[tx.CurrentIndex] = true
+  		trytes[tx.CurrentIndex] = *tx
+  	}
+  	return nil
+  }
+
+  func (r *remotePow) Info() string {
+  	return "remote (" + r.url + ")"
+  }
+
+  //newPowProvider returns PowProvider specified in aidosd.conf.
+  func newPowProvider(kind string, workers int, url string) (PowProvider, error) {
+  	switch kind {
+  	case "", "local":
+  		return newLocalPow(), nil
+  	case "pool":
+  		if workers <= 0 {
+  			workers = runtime.NumCPU()
+  		}
+  		return newPoolPow(workers), nil
+  	case "remote":
+  		if url == "" {
+  			return nil, errors.New("pow_url must be set for remote PoW")
+  		}
+  		return newRemotePow(url), nil
+  	}
+  	return nil, errors.New("pow must be local, pool or remote")
+  }
+
+  //powStats wraps PowProvider and counts PoWs.
+  type powStats struct {
+  	PowProvider
+  	mutex    sync.Mutex
+  	running  int
+  	bundles  int
+  	txs      int
+  	failures int
+  	elapsed  time.Duration
+  	last     time.Time
+  }
+
+  func (s *powStats) Attach(tra *gadk.GetTransactionsToApproveResponse, trytes []gadk.Transaction, mwm int64) error {
+  	s.mutex.Lock()
+  	s.running++
+  	s.mutex.Unlock()
+  	start := time.Now()
+  	err := s.PowProvider.Attach(tra, trytes, mwm)
+  	s.mutex.Lock()
+  	defer s.mutex.Unlock()
+  	s.running--
+  	s.last = time.Now()
+  	if err != nil {
+  		s.failures++
+  		return err
+  	}
+  	s.bundles++
+  	s.txs += len(trytes)
+  	s.elapsed += s.last.Sub(start)
+  	return nil
+  }
+
+  type powinfo struct {
+  	Provider     string  `json:"provider"`
+  	Running      int     `json:"running"`
+  	Bundles      int     `json:"bundles"`
+  	Transactions int     `json:"transactions"`
+  	Failures     int     `json:"failures"`
+  	AverageTime  float64 `json:"averagetime"`
+  	LastTime     int64   `json:"lasttime"`
+  }
+
+  //getpowinfo returns the PoW provider and statistics of PoWs since aidosd started.
+  func getpowinfo(conf *Conf, req *Request, res *Response) error {
+  	var p struct{}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	s := conf.pow
+  	s.mutex.Lock()
+  	defer s.mutex.Unlock()
+  	info := &powinfo{
+  		Provider:     s.Info(),
+  		Running:      s.running,
+  		Bundles:      s.bundles,
+  		Transactions: s.txs,
+  		Failures:     s.failures,
+  	}
+  	if s.bundles > 0 {
+  		info.AverageTime = s.elapsed.Seconds() / float64(s.bundles)
+  	}
+  	if !s.last.IsZero() {
+  		info.LastTime = s.last.Unix()
+  	}
+  	res.Result = info
+  	return nil
+  }
@@ -0,0 +1,164 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+
+  import (
+  	"encoding/json"
+  	"net/http"
+  	"net/http/httptest"
+  	"sync"
+  	"testing"
+  	"time"
+
+  	"github.com/AidosKuneen/gadk"
+  )
+
+  func TestPoolPow(t *testing.T) {
+  	var mutex sync.Mutex
+  	var running, max int
+  	p := newPoolPow(2)
+  	//count cores used by PoW functions of gadk, which run with gadk.PowProcs goroutines.
+  	p.pow = func(gadk.Trytes, int) (gadk.Trytes, error) {
+  		mutex.Lock()
+  		running += gadk.PowProcs
+  		if running > max {
+  			max = running
+  		}
+  		mutex.Unlock()
+  		time.Sleep(10 * time.Millisecond)
+  		mutex.Lock()
+  		running -= gadk.PowProcs
+  		mutex.Unlock()
+  		return gadk.EmptyHash, nil
+  	}
+  	tra := &gadk.GetTransactionsToApproveResponse{
+  		TrunkTransaction:  trunk,
+  		BranchTransaction: branch,
+  	}
+  	s := &powStats{PowProvider: p}
+  	var wg sync.WaitGroup
+  	for i := 0; i < 5; i++ {
+  		wg.Add(1)
+  		go func() {
+  			defer wg.Done()
+  			if err := s.Attach(tra, make([]gadk.Transaction, 3), 13); err != nil {
+  				t.Error(err)
+  			}
+  		}()
+  	}
+  	wg.Wait()
+  	if max != 2 {
+  		t.Error("number of cores must be 2", max)
+  	}
+  	if s.bundles != 5 || s.txs != 15 || s.failures != 0 || s.running != 0 {
+  		t.Error("invalid stats", s.bundles, s.txs, s.failures, s.running)
+  	}
+  }
+
+  func TestRemotePow(t *testing.T) {
+  	local := newLocalPow()
+  	//a stand-in of a node which does attachToTangle.
+  	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+  		var req attachRequest
+  		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+  			t.Fatal(err)
+  		}
+  		if req.Command != "attachToTangle" {
+  			t.Error("invalid command", req.Command)
+  		}
+  		ts := make([]gadk.Transaction, len(req.Trytes))
+  		for i, tr := range req.Trytes {
+  			tx, err := gadk.NewTransaction(tr)
+  			if err != nil {
+  				t.Fatal(err)
+  			}
+  			ts[len(ts)-1-i] = *tx
+  		}
+  		tra := &gadk.GetTransactionsToApproveResponse{
+  			TrunkTransaction:  req.TrunkTransaction,
+  			BranchTransaction: req.BranchTransaction,
+  		}
+  		var res attachResponse
+  		if err := local.Attach(tra, ts, req.MinWeightMagnitude); err != nil {
+  			res.Error = err.Error()
+  		}
+  		for _, tx := range ts {
+  			res.Trytes = append(res.Trytes, tx.Trytes())
+  		}
+  		if err := json.NewEncoder(w).Encode(&res); err != nil {
+  			t.Fatal(err)
+  		}
+  	}))
+  	defer srv.Close()
+
+  	conf := prepareTest(t)
+  	conf.api = newdummy(nil, t)
+  	p, err := newPowProvider("remote", 0, srv.URL)
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	conf.pow = &powStats{PowProvider: p}
+  	var bd gadk.Bundle
+  	bd.Add(2, gadk.Address("C"+gadk.EmptyAddress[1:]), 0, time.Now(), gadk.EmptyHash)
+  	bd.Finalize(nil)
+  	ts := []gadk.Transaction(bd)
+  	if err := PowTrytes(conf.api, gadk.Depth, ts, conf.mwm(), conf.pow); err != nil {
+  		t.Fatal(err)
+  	}
+  	if ts[1].TrunkTransaction != trunk || ts[0].TrunkTransaction != ts[1].Hash() {
+  		t.Error("invalid trunk")
+  	}
+
+  	var resp Response
+  	if err := getpowinfo(conf, &Request{Method: "getpowinfo"}, &resp); err != nil {
+  		t.Fatal(err)
+  	}
+  	info, ok := resp.Result.(*powinfo)
+  	if !ok {
+  		t.Fatal("result must be powinfo")
+  	}
+  	if info.Provider != "remote ("+srv.URL+")" || info.Bundles != 1 || info.Transactions != 2 || info.LastTime == 0 {
+  		t.Error("invalid powinfo", info)
+  	}
+  }
+
+  func TestNewPowProvider(t *testing.T) {
+  	if _, err := newPowProvider("remote", 0, ""); err == nil {
+  		t.Error("remote without url should be error")
+  	}
+  	if _, err := newPowProvider("gpu", 0, ""); err == nil {
+  		t.Error("unknown provider should be error")
+  	}
+  	p, err := newPowProvider("pool", 3, "")
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	if pp, ok := p.(*poolPow); !ok || cap(pp.workers) != 3 {
+  		t.Error("invalid pool", p)
+  	}
+  	p, err = newPowProvider("", 0, "")
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	if _, ok := p.(*localPow); !ok {
+  		t.Error("default provider must be local", p)
+  	}
+  }
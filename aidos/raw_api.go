@@ -0,0 +1,283 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+  import (
+  	"log"
+  	"time"
+
+  	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
+  )
+
+  //A raw transaction is a bundle serialized as concatenated trytes of its transactions,
+  //so it can be carried to an offline aidosd and back as a string.
+
+  //encodeRaw serializes bd.
+  func encodeRaw(bd gadk.Bundle) gadk.Trytes {
+  	var raw gadk.Trytes
+  	for i := range bd {
+  		raw += bd[i].Trytes()
+  	}
+  	return raw
+  }
+
+  //decodeRaw deserializes raw to a bundle.
+  func decodeRaw(raw string) (gadk.Bundle, error) {
+  	n := len((&gadk.Transaction{}).Trytes())
+  	if len(raw) == 0 || n == 0 || len(raw)%n != 0 {
+  		return nil, newErr(RPCDeserializationError, "TX decode failed")
+  	}
+  	t, err := gadk.ToTrytes(raw)
+  	if err != nil {
+  		return nil, newErr(RPCDeserializationError, "TX decode failed: "+err.Error())
+  	}
+  	bd := make(gadk.Bundle, 0, len(t)/n)
+  	for i := 0; i < len(t); i += n {
+  		tx, err := gadk.NewTransaction(t[i : i+n])
+  		if err != nil {
+  			return nil, newErr(RPCDeserializationError, "TX decode failed: "+err.Error())
+  		}
+  		bd = append(bd, *tx)
+  	}
+  	return bd, nil
+  }
+
+  type createrawtransactionParams struct {
+  	Inputs  interface{} `param:"inputs,required"`
+  	Outputs interface{} `param:"outputs,required"`
+  }
+
+  //createrawtransaction makes a bundle which has only outputs.
+  //Inputs are selected from the wallet by fundrawtransaction.
+  func createrawtransaction(conf *Conf, req *Request, res *Response) error {
+  	var p createrawtransactionParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	if in, ok := p.Inputs.([]interface{}); !ok || len(in) != 0 {
+  		return newErr(RPCInvalidParameter, "inputs must be empty, use fundrawtransaction to add inputs")
+  	}
+  	trs, err := toTransfers(conf, p.Outputs)
+  	if err != nil {
+  		return err
+  	}
+  	bd, _, _ := addOutputs(trs)
+  	res.Result = encodeRaw(bd)
+  	return nil
+  }
+
+  type fundrawtransactionParams struct {
+  	Hex     string                 `param:"hexstring,required"`
+  	Options map[string]interface{} `param:"options"`
+  }
+
+  type fundresult struct {
+  	Hex       gadk.Trytes `json:"hex"`
+  	Fee       Amount      `json:"fee"`
+  	ChangePos int         `json:"changepos"`
+  }
+
+  //fundrawtransaction adds inputs and a change output to a bundle made by createrawtransaction.
+  //Balances of inputs are deducted as sendmany does, and the bundle is put to the outbox as funded,
+  //so that they can be given back by abandontransaction. The change address can be specified by
+  //the changeAddress option, which is needed for watch-only accounts, and the coin selection policy by
+  //the coinSelection option.
+  func fundrawtransaction(conf *Conf, req *Request, res *Response) error {
+  	mutex.Lock()
+  	defer mutex.Unlock()
+  	var p fundrawtransactionParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	bd, err := decodeRaw(p.Hex)
+  	if err != nil {
+  		return err
+  	}
+  	var changeAdr gadk.Address
+  	if v, ok := p.Options["changeAddress"]; ok {
+  		str, ok := v.(string)
+  		if !ok {
+  			return newErr(RPCInvalidParameter, "changeAddress must be a string")
+  		}
+  		if changeAdr, err = gadk.ToAddress(str); err != nil {
+  			return newErr(RPCInvalidAddressOrKey, "Invalid address: "+err.Error())
+  		}
+  	}
+  	var policy string
+  	if v, ok := p.Options["coinSelection"]; ok {
+  		if policy, ok = v.(string); !ok {
+  			return newErr(RPCInvalidParameter, "coinSelection must be a string")
+  		}
+  	}
+  	if policy, err = conf.coinSelection(policy); err != nil {
+  		return err
+  	}
+  	trs := make([]gadk.Transfer, len(bd))
+  	for i, tx := range bd {
+  		if tx.Value < 0 {
+  			return newErr(RPCInvalidParameter, "transaction is already funded")
+  		}
+  		trs[i] = gadk.Transfer{
+  			Address: tx.Address,
+  			Value:   tx.Value,
+  			Message: tx.SignatureMessageFragment,
+  			Tag:     tx.Tag,
+  		}
+  	}
+  	return db.Update(func(tx *bolt.Tx) error {
+  		ac, err := sendingAccount(tx, "*")
+  		if err != nil {
+  			return err
+  		}
+  		funded, err := fundTransfers(conf.api, ac, trs, changeAdr, policy)
+  		if err != nil {
+  			return err
+  		}
+  		r := fundresult{
+  			Hex:       encodeRaw(funded),
+  			ChangePos: -1,
+  		}
+  		for i, t := range funded {
+  			if i >= len(trs) && t.Value > 0 {
+  				r.ChangePos = i
+  			}
+  		}
+  		res.Result = &r
+  		if hasInputs(funded) {
+  			if err = queueBundle(tx, ac, funded, trs, outFunded); err != nil {
+  				return err
+  			}
+  		}
+  		return putAccount(tx, ac)
+  	})
+  }
+
+  type signrawtransactionParams struct {
+  	Hex string `param:"hexstring,required"`
+  }
+
+  type signresult struct {
+  	Hex      gadk.Trytes `json:"hex"`
+  	Complete bool        `json:"complete"`
+  }
+
+  //signrawtransaction signs inputs of a bundle made by fundrawtransaction with the seed in the wallet.
+  //It doesn't need any connection to nodes, so it can run on an offline aidosd.
+  func signrawtransaction(conf *Conf, req *Request, res *Response) error {
+  	if !unlocker.isUnlocked() {
+  		return errNotPrivileged
+  	}
+  	mutex.RLock()
+  	defer mutex.RUnlock()
+  	var p signrawtransactionParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	bd, err := decodeRaw(p.Hex)
+  	if err != nil {
+  		return err
+  	}
+  	err = db.View(func(tx *bolt.Tx) error {
+  		var ac *Account
+  		for _, t := range bd {
+  			if t.Value >= 0 {
+  				continue
+  			}
+  			ac2, _, err := findAddress(tx, t.Address)
+  			if err != nil {
+  				return err
+  			}
+  			if ac2 == nil {
+  				return newErr(RPCInvalidAddressOrKey, "input address is not in the wallet")
+  			}
+  			if ac != nil && ac.Name != ac2.Name {
+  				return newErr(RPCInvalidParameter, "inputs must be in one account")
+  			}
+  			ac = ac2
+  		}
+  		if ac == nil {
+  			return nil
+  		}
+  		return ac.withSeed(func(seed gadk.Trytes) error {
+  			return signInputs(ac, seed, bd)
+  		})
+  	})
+  	if err != nil {
+  		return err
+  	}
+  	res.Result = &signresult{
+  		Hex:      encodeRaw(bd),
+  		Complete: bd.IsValid() == nil,
+  	}
+  	return nil
+  }
+
+  type sendrawtransactionParams struct {
+  	Hex           string `param:"hexstring,required"`
+  	AllowHighFees bool   `param:"allowhighfees"`
+  }
+
+  //sendrawtransaction does PoW of a bundle signed by signrawtransaction and broadcasts it.
+  func sendrawtransaction(conf *Conf, req *Request, res *Response) error {
+  	var p sendrawtransactionParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	bd, err := decodeRaw(p.Hex)
+  	if err != nil {
+  		return err
+  	}
+  	if err = bd.IsValid(); err != nil {
+  		return newErr(RPCVerifyError, "invalid bundle: "+err.Error())
+  	}
+  	log.Println("starting PoW... (", conf.pow.Info(), ")")
+  	ts := []gadk.Transaction(bd)
+  	if err = PowTrytes(conf.api, gadk.Depth, ts, conf.mwm(), conf.pow); err != nil {
+  		return err
+  	}
+  	stateMutex.Lock()
+  	defer stateMutex.Unlock()
+  	var o *outgoing
+  	if err = db.View(func(tx *bolt.Tx) error {
+  		var errr error
+  		o, errr = getOutgoing(tx, bd.Hash())
+  		return errr
+  	}); err != nil {
+  		return err
+  	}
+  	if o != nil && o.State == outFailed {
+  		return newErr(RPCVerifyError, "transaction was abandoned")
+  	}
+  	if err = broadcast(conf.api, ts); err != nil {
+  		return err
+  	}
+  	//the outbox checks confirmations and reattaches it from now on.
+  	if o != nil && o.State == outFunded {
+  		o.Raw = encodeRaw(bd)
+  		o.Tails = []gadk.Trytes{ts[0].Hash()}
+  		o.Attached = time.Now()
+  		if err = setOutgoingState(o, outBroadcast, nil); err != nil {
+  			return err
+  		}
+  	}
+  	res.Result = bd.Hash()
+  	return nil
+  }
@@ -0,0 +1,192 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+
+  package aidos
+
+  import (
+  	"testing"
+
+  	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
+  )
+
+  func TestRawTransaction(t *testing.T) {
+  	conf, d1 := preparetSend(t)
+  	d1.isConf = true
+  	conf.api = d1
+  	if _, err := Walletnotify(conf); err != nil {
+  		t.Error(err)
+  	}
+  	call := func(f func(*Conf, *Request, *Response) error, params ...interface{}) (*Response, error) {
+  		req := &Request{
+  			JSONRPC: "1.0",
+  			ID:      "curltest",
+  			Params:  params,
+  		}
+  		var resp Response
+  		err := f(conf, req, &resp)
+  		return &resp, err
+  	}
+  	adr1 := gadk.Address("A" + gadk.EmptyAddress[1:])
+
+  	if _, err := call(createrawtransaction, []interface{}{"dummy"},
+  		map[string]interface{}{string(adr1.WithChecksum()): 0.1}); err == nil {
+  		t.Error("inputs should be refused")
+  	}
+  	resp, err := call(createrawtransaction, []interface{}{},
+  		map[string]interface{}{string(adr1.WithChecksum()): 0.1})
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	raw := resp.Result.(gadk.Trytes)
+  	bd, err := decodeRaw(string(raw))
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	if len(bd) != 1 || bd[0].Address != adr1 || bd[0].Value != int64(0.1*100000000) {
+  		t.Fatal("invalid raw transaction", bd)
+  	}
+  	if _, err = decodeRaw(string(raw[1:])); err == nil {
+  		t.Error("broken raw transaction should be error")
+  	}
+
+  	if resp, err = call(fundrawtransaction, string(raw)); err != nil {
+  		t.Fatal(err)
+  	}
+  	fr := resp.Result.(*fundresult)
+  	funded, err := decodeRaw(string(fr.Hex))
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	var sum int64
+  	for _, tx := range funded {
+  		sum += tx.Value
+  	}
+  	if !hasInputs(funded) || sum != 0 || funded[0].Address != adr1 {
+  		t.Error("invalid funded transaction", funded)
+  	}
+  	if fr.ChangePos >= 0 && funded[fr.ChangePos].Value <= 0 {
+  		t.Error("invalid changepos", fr.ChangePos)
+  	}
+  	if _, err = call(fundrawtransaction, string(fr.Hex)); err == nil {
+  		t.Error("funded transaction should not be funded again")
+  	}
+  	if o := getOutgoingT(t, funded.Hash()); o == nil || o.State != outFunded {
+  		t.Error("funded transaction should be in the outbox", o)
+  	}
+
+  	if _, err = call(signrawtransaction, string(fr.Hex)); err != errNotPrivileged {
+  		t.Error("signing should need walletpassphrase", err)
+  	}
+  	if _, err = call(sendrawtransaction, string(fr.Hex)); err == nil {
+  		t.Error("unsigned transaction should not be sent")
+  	}
+  	testwalletpassphrase2(conf, d1)
+  	last := -1
+  	for i, tx := range funded {
+  		if tx.Value < 0 {
+  			last = i
+  		}
+  	}
+  	if _, err = call(signrawtransaction, string(encodeRaw(funded[:last+1]))); err != errMalformedInput {
+  		t.Error("truncated transaction should not be signed", err)
+  	}
+  	if resp, err = call(signrawtransaction, string(fr.Hex)); err != nil {
+  		t.Fatal(err)
+  	}
+  	sr := resp.Result.(*signresult)
+  	if !sr.Complete {
+  		t.Error("should be signed")
+  	}
+  	signed, err := decodeRaw(string(sr.Hex))
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	if err = signed.IsValid(); err != nil {
+  		t.Error(err)
+  	}
+
+  	go func() {
+  		<-d1.ch
+  	}()
+  	if resp, err = call(sendrawtransaction, string(sr.Hex)); err != nil {
+  		t.Fatal(err)
+  	}
+  	b := gadk.Bundle(d1.broadcasted)
+  	if err = b.IsValid(); err != nil {
+  		t.Error(err)
+  	}
+  	if len(d1.stored) != len(signed) || b.Hash() != resp.Result || b.Hash() != signed.Hash() {
+  		t.Error("invalid broadcasted bundle")
+  	}
+  	for _, tx := range d1.broadcasted {
+  		if !HasValidNonce(&tx, int(conf.mwm())) {
+  			t.Error("invalid nonce")
+  		}
+  	}
+  	if o := getOutgoingT(t, funded.Hash()); o == nil || o.State != outBroadcast || len(o.Tails) != 1 {
+  		t.Error("sent transaction should be supervised by the outbox", o)
+  	}
+
+  	//balances deducted by fundrawtransaction are given back by abandontransaction.
+  	total := func() int64 {
+  		var v int64
+  		if err := db.View(func(tx *bolt.Tx) error {
+  			acs, err := listAccount(tx)
+  			for _, ac := range acs {
+  				v += ac.totalValueWithChange()
+  			}
+  			return err
+  		}); err != nil {
+  			t.Fatal(err)
+  		}
+  		return v
+  	}
+  	before := total()
+  	if resp, err = call(fundrawtransaction, string(raw)); err != nil {
+  		t.Fatal(err)
+  	}
+  	funded, err = decodeRaw(string(resp.Result.(*fundresult).Hex))
+  	if err != nil {
+  		t.Fatal(err)
+  	}
+  	if _, err = call(abandontransaction, string(funded.Hash())); err != nil {
+  		t.Fatal(err)
+  	}
+  	if after := total(); after != before {
+  		t.Error("balances should be given back", before, after)
+  	}
+  	if o := getOutgoingT(t, funded.Hash()); o == nil || o.State != outFailed {
+  		t.Error("abandoned transaction should be failed", o)
+  	}
+  }
+
+  func getOutgoingT(t *testing.T, hash gadk.Trytes) *outgoing {
+  	var o *outgoing
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		var err error
+  		o, err = getOutgoing(tx, hash)
+  		return err
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	return o
+  }
@@ -21,99 +21,136 @@
   package aidos
 
   import (
-  	"bytes"
-  	"crypto/sha256"
   	"encoding/json"
-  	"errors"
+  	"sort"
+  	"strings"
   	"github.com/AidosKuneen/gadk"
   	"github.com/boltdb-go/bolt"
-  	"sync"
   	"time"
   )
 
-  var privileged bool
-  var pmutex sync.RWMutex
-
-  func send(acc string, conf *Conf, trs []gadk.Transfer) (gadk.Trytes, error) {
+  //mwm returns the min weight magnitude of PoW for the network.
+  func (c *Conf) mwm() int64 {
   	var mwm int64 = 18
-  	if conf.Testnet {
+  	if c.Testnet {
   		mwm = 13
   	}
-    if conf.V2 {
+    if c.V2 {
   		mwm = 15
   	}
-  	var result gadk.Trytes
-  	err := db.Update(func(tx *bolt.Tx) error {
-  		var ac *Account
-  		var err error
-  		if acc != "*" {
-  			ac, err = getAccount(tx, acc)
-  		} else {
-  			acs, errr := listAccount(tx)
-  			if errr != nil {
-  				return errr
-  			}
-  			if len(acs) == 0 {
-  				return errors.New("no accounts")
-  			}
-  			ac = &acs[0]
+  	return mwm
+  }
+
+  //sendingAccount returns the account named acc, or the first account if acc is "*".
+  func sendingAccount(tx *bolt.Tx, acc string) (*Account, error) {
+  	var ac *Account
+  	var err error
+  	if acc != "*" {
+  		ac, err = getAccount(tx, acc)
+  	} else {
+  		acs, errr := listAccount(tx)
+  		if errr != nil {
+  			return nil, errr
+  		}
+  		if len(acs) == 0 {
+  			return nil, newErr(RPCWalletError, "no accounts")
   		}
+  		ac = &acs[0]
+  	}
+  	if err != nil {
+  		return nil, err
+  	}
+  	if ac == nil {
+  		return nil, newErr(RPCWalletInvalidAccountName, "accout not found")
+  	}
+  	return ac, nil
+  }
+
+  //send sends trs from the account acc. Only confirmed balances are spent,
+  //or pending changes of bundles sent before are also spent if minconf is 0.
+  func send(acc string, conf *Conf, trs []gadk.Transfer, policy string, minconf int) ([]gadk.Trytes, error) {
+  	if err := checkMinconf(minconf); err != nil {
+  		return nil, err
+  	}
+  	var result []gadk.Trytes
+  	err := db.Update(func(tx *bolt.Tx) error {
+  		ac, err := sendingAccount(tx, acc)
   		if err != nil {
   			return err
   		}
-  		if ac == nil {
-  			return errors.New("accout not found")
+  		if ac.WatchOnly {
+  			return errWatchOnly
+  		}
+  		var hashes []gadk.Trytes
+  		if minconf == 0 {
+  			err = ac.withPendingChange(func() error {
+  				var errr error
+  				hashes, errr = Send(tx, conf, ac, trs, policy)
+  				return errr
+  			})
+  		} else {
+  			hashes, err = Send(tx, conf, ac, trs, policy)
   		}
-  		bhash, err := Send(conf, ac, mwm, trs)
   		if err == nil {
   			if errr := putAccount(tx, ac); errr != nil {
   				return errr
   			}
-  			result = bhash
+  			result = hashes
   		}
   		return err
   	})
   	return result, err
   }
 
+  type sendmanyParams struct {
+  	Account         string      `param:"fromaccount|dummy,required"`
+  	Amounts         interface{} `param:"amounts,required"`
+  	Minconf         int         `param:"minconf"`
+  	Comment         string      `param:"comment"`
+  	SubtractFeeFrom interface{} `param:"subtractfeefrom"`
+  	CoinSelection   string      `param:"coin_selection"`
+  }
+
   func sendmany(conf *Conf, req *Request, res *Response) error {
-  	pmutex.RLock()
-  	if !privileged {
-  		pmutex.RUnlock()
-  		return errors.New("not priviledged")
+  	if !unlocker.isUnlocked() {
+  		return errNotPrivileged
   	}
-  	pmutex.RUnlock()
   	mutex.Lock()
   	defer mutex.Unlock()
-  	data, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("invalid params")
+  	p := sendmanyParams{
+  		Minconf: 1,
+  	}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
   	}
-  	if len(data) < 2 || len(data) > 5 {
-  		return errors.New("invalid param length")
+  	trs, err := toTransfers(conf, p.Amounts)
+  	if err != nil {
+  		return err
   	}
-  	acc, ok := data[0].(string)
-  	if !ok {
-  		return errors.New("invalid account")
+  	hashes, err := send(p.Account, conf, trs, p.CoinSelection, p.Minconf)
+  	if err != nil {
+  		return err
   	}
-  	target := make(map[string]float64)
-  	switch data[1].(type) {
+  	//returns the first hash even if the payout is split into several bundles.
+  	//All of them are shown with the hash as payout in listpendingtransfers.
+  	res.Result = hashes[0]
+  	return nil
+  }
+
+  //toTransfers converts amounts, a map (or a JSON string of it) from addresses to amounts, to transfers.
+  func toTransfers(conf *Conf, amounts interface{}) ([]gadk.Transfer, error) {
+  	var target map[string]interface{}
+  	switch t := amounts.(type) {
   	case string:
-  		t := data[1].(string)
-  		if err := json.Unmarshal([]byte(t), &target); err != nil {
-  			return err
+  		d := json.NewDecoder(strings.NewReader(t))
+  		d.UseNumber()
+  		if err := d.Decode(&target); err != nil {
+  			return nil, newErr(RPCInvalidParams, err.Error())
   		}
   	case map[string]interface{}:
-  		t := data[1].(map[string]interface{})
-  		for k, v := range t {
-  			f, ok := v.(float64)
-  			if !ok {
-  				return errors.New("param must be a  map string")
-  			}
-  			target[k] = f
-  		}
+  		target = t
   	default:
-  		return errors.New("param must be a  map string")
+  		return nil, newErr(RPCInvalidParams, "param must be a  map string")
   	}
   	trs := make([]gadk.Transfer, len(target))
   	i := 0
@@ -121,127 +158,186 @@
   	for k, v := range target {
   		trs[i].Address, err = gadk.ToAddress(k)
   		if err != nil {
-  			return err
+  			return nil, newErr(RPCInvalidAddressOrKey, "Invalid address: "+err.Error())
   		}
-  		trs[i].Value = int64(v * 100000000)
+  		a, errr := toAmount(v)
+  		if errr != nil {
+  			return nil, errr
+  		}
+  		trs[i].Value = int64(a)
   		trs[i].Tag = gadk.Trytes(conf.Tag)
   		i++
   	}
-  	res.Result, err = send(acc, conf, trs)
-  	return err
+  	//sorts in order of addresses so that bundles are split in the same way.
+  	sort.Slice(trs, func(i, j int) bool {
+  		return trs[i].Address < trs[j].Address
+  	})
+  	return trs, nil
+  }
+
+  type sendfromParams struct {
+  	Account       string  `param:"fromaccount,required"`
+  	Address       string  `param:"toaddress,required"`
+  	Amount        Amount  `param:"amount,required"`
+  	Minconf       int     `param:"minconf"`
+  	Comment       string  `param:"comment"`
+  	CommentTo     string  `param:"comment_to"`
+  	CoinSelection string  `param:"coin_selection"`
   }
 
   func sendfrom(conf *Conf, req *Request, res *Response) error {
   	var err error
-  	pmutex.RLock()
-  	if !privileged {
-  		pmutex.RUnlock()
-  		return errors.New("not priviledged")
+  	if !unlocker.isUnlocked() {
+  		return errNotPrivileged
   	}
-  	pmutex.RUnlock()
   	mutex.Lock()
   	defer mutex.Unlock()
-  	data, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("invalid params")
-  	}
-  	if len(data) < 3 || len(data) > 6 {
-  		return errors.New("invalid params")
+  	p := sendfromParams{
+  		Minconf: 1,
   	}
-  	acc, ok := data[0].(string)
-  	if !ok {
-  		return errors.New("invalid account")
+  	if err = parseParams(req, &p); err != nil {
+  		return err
   	}
   	var tr gadk.Transfer
   	tr.Tag = gadk.Trytes(conf.Tag)
-  	adrstr, ok := data[1].(string)
-  	if !ok {
-  		return errors.New("invalid address")
+  	tr.Address, err = gadk.ToAddress(p.Address)
+  	if err != nil {
+  		return newErr(RPCInvalidAddressOrKey, "Invalid address: "+err.Error())
   	}
-  	tr.Address, err = gadk.ToAddress(adrstr)
+  	tr.Value = int64(p.Amount)
+  	hashes, err := send(p.Account, conf, []gadk.Transfer{tr}, p.CoinSelection, p.Minconf)
   	if err != nil {
   		return err
   	}
-  	value, ok := data[2].(float64)
-  	if !ok {
-  		return errors.New("invalid value")
-  	}
-  	tr.Value = int64(value * 100000000)
-  	res.Result, err = send(acc, conf, []gadk.Transfer{tr})
-  	return err
+  	res.Result = hashes[0]
+  	return nil
+  }
+
+  type sendtoaddressParams struct {
+  	Address               string  `param:"address,required"`
+  	Amount                Amount  `param:"amount,required"`
+  	Comment               string  `param:"comment"`
+  	CommentTo             string  `param:"comment_to"`
+  	SubtractFeeFromAmount bool    `param:"subtractfeefromamount"`
+  	CoinSelection         string  `param:"coin_selection"`
   }
+
   func sendtoaddress(conf *Conf, req *Request, res *Response) error {
   	var err error
-  	pmutex.RLock()
-  	if !privileged {
-  		pmutex.RUnlock()
-  		return errors.New("not priviledged")
+  	if !unlocker.isUnlocked() {
+  		return errNotPrivileged
   	}
-  	pmutex.RUnlock()
   	mutex.Lock()
   	defer mutex.Unlock()
   	var tr gadk.Transfer
   	tr.Tag = gadk.Trytes(conf.Tag)
 
-  	data, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("invalid params")
-  	}
-  	if len(data) > 5 || len(data) < 2 {
-  		return errors.New("invalid params")
-  	}
-  	adrstr, ok := data[0].(string)
-  	if !ok {
-  		return errors.New("invalid address")
+  	var p sendtoaddressParams
+  	if err = parseParams(req, &p); err != nil {
+  		return err
   	}
-  	value, ok := data[1].(float64)
-  	if !ok {
-  		return errors.New("invalid value")
+  	tr.Address, err = gadk.ToAddress(p.Address)
+  	if err != nil {
+  		return newErr(RPCInvalidAddressOrKey, "Invalid address: "+err.Error())
   	}
-  	tr.Address, err = gadk.ToAddress(adrstr)
+
+  	tr.Value = int64(p.Amount)
+  	hashes, err := send("*", conf, []gadk.Transfer{tr}, p.CoinSelection, 1)
   	if err != nil {
   		return err
   	}
+  	res.Result = hashes[0]
+  	return nil
+  }
 
-  	tr.Value = int64(value * 100000000)
-  	res.Result, err = send("*", conf, []gadk.Transfer{tr})
-  	return err
+  type walletpassphraseParams struct {
+  	Passphrase string  `param:"passphrase,required"`
+  	Timeout    float64 `param:"timeout,required"`
   }
 
   func walletpassphrase(conf *Conf, req *Request, res *Response) error {
-  	pmutex.RLock()
-  	if privileged {
-  		pmutex.RUnlock()
-  		return nil
+  	var p walletpassphraseParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
   	}
-  	pmutex.RUnlock()
-  	data, ok := req.Params.([]interface{})
-  	if !ok {
-  		return errors.New("invalid params")
+  	if p.Timeout < 0 {
+  		return newErr(RPCInvalidParameter, "Timeout cannot be negative.")
   	}
-  	if len(data) != 2 {
-  		return errors.New("invalid param length")
+  	if !conf.PassPhrase {
+  		return newErr(RPCWalletWrongEncState, "running with an unencrypted wallet, but walletpassphrase was called")
   	}
-  	pwd, ok := data[0].(string)
-  	if !ok {
-  		return errors.New("invalid password")
+  	//clamp before converting, or huge timeouts overflow time.Duration.
+  	if max := maxUnlockTime.Seconds(); p.Timeout > max {
+  		p.Timeout = max
   	}
-  	sec, ok := data[1].(float64)
+  	mutex.RLock()
+  	ok := block.verify([]byte(p.Passphrase))
+  	mutex.RUnlock()
   	if !ok {
-  		return errors.New("invalid time")
-  	}
-  	sum := sha256.Sum256([]byte(pwd))
-  	if !bytes.Equal(sum[:], block.pwd256) {
-  		return errors.New("invalid password")
-  	}
-  	go func() {
-  		pmutex.Lock()
-  		privileged = true
-  		pmutex.Unlock()
-  		time.Sleep(time.Second * time.Duration(sec))
-  		pmutex.Lock()
-  		privileged = false
-  		pmutex.Unlock()
-  	}()
+  		return newErr(RPCWalletPassphraseIncorrect, "invalid password")
+  	}
+  	unlocker.unlock(time.Duration(p.Timeout * float64(time.Second)))
+  	return nil
+  }
+
+  func walletlock(conf *Conf, req *Request, res *Response) error {
+  	var p struct{}
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	if !conf.PassPhrase {
+  		return newErr(RPCWalletWrongEncState, "running with an unencrypted wallet, but walletlock was called")
+  	}
+  	unlocker.lock()
   	return nil
   }
+
+  type walletpassphrasechangeParams struct {
+  	Oldpassphrase string `param:"oldpassphrase,required"`
+  	Newpassphrase string `param:"newpassphrase,required"`
+  }
+
+  func walletpassphrasechange(conf *Conf, req *Request, res *Response) error {
+  	var p walletpassphrasechangeParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	mutex.Lock()
+  	defer mutex.Unlock()
+  	switch err := ChangePassword([]byte(p.Oldpassphrase), []byte(p.Newpassphrase)); err {
+  	case nil:
+  		return nil
+  	case errIncorrectPassword:
+  		return newErr(RPCWalletPassphraseIncorrect, "invalid password")
+  	case errEmptyPassword:
+  		return newErr(RPCInvalidParameter, err.Error())
+  	case errNotEncrypted:
+  		return newErr(RPCWalletWrongEncState, "running with an unencrypted wallet, but walletpassphrasechange was called")
+  	default:
+  		return err
+  	}
+  }
+
+  type encryptwalletParams struct {
+  	Passphrase string `param:"passphrase,required"`
+  }
+
+  func encryptwallet(conf *Conf, req *Request, res *Response) error {
+  	var p encryptwalletParams
+  	if err := parseParams(req, &p); err != nil {
+  		return err
+  	}
+  	mutex.Lock()
+  	defer mutex.Unlock()
+  	switch err := EncryptWallet([]byte(p.Passphrase)); err {
+  	case nil:
+  		res.Result = "wallet encrypted"
+  		return nil
+  	case errEmptyPassword:
+  		return newErr(RPCInvalidParameter, err.Error())
+  	case errEncrypted:
+  		return newErr(RPCWalletWrongEncState, "running with an encrypted wallet, but encryptwallet was called")
+  	default:
+  		return err
+  	}
+  }
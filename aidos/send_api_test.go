@@ -23,6 +23,7 @@
   import (
   	"github.com/AidosKuneen/gadk"
   	"github.com/boltdb-go/bolt"
+  	"reflect"
   	"testing"
   	"time"
   )
@@ -80,6 +81,189 @@
   	testsendtoaddress(conf, d1)
   }
   
+  func TestSendAfterPassphraseChange(t *testing.T) {
+  	conf, d1 := preparetSend(t)
+  	d1.isConf = true
+  	conf.api = d1
+  	if _, err := Walletnotify(conf); err != nil {
+  		t.Error(err)
+  	}
+  	conf.accountNo = 0
+  	ListAndSelectAccount(conf)
+  	defer func() {
+  		globalAccountNo = -1
+  	}()
+  	req := &Request{
+  		JSONRPC: "1.0",
+  		ID:      "curltest",
+  		Method:  "walletpassphrasechange",
+  		Params:  []interface{}{"test", "new"},
+  	}
+  	if err := walletpassphrasechange(conf, req, &Response{}); err != nil {
+  		t.Fatal(err)
+  	}
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		ac, err := getAccount(tx, "")
+  		if err != nil {
+  			return err
+  		}
+  		return ac.withSeed(func(gadk.Trytes) error { return nil })
+  	}); err != nil {
+  		t.Fatal("the selected account must be decrypted with the new password", err)
+  	}
+  	req = &Request{
+  		JSONRPC: "1.0",
+  		ID:      "curltest",
+  		Method:  "walletpassphrase",
+  		Params:  []interface{}{"new", float64(60)},
+  	}
+  	if err := walletpassphrase(conf, req, &Response{}); err != nil {
+  		t.Fatal(err)
+  	}
+  	testsendtoaddress(conf, d1)
+  }
+
+  func TestSendSelectedAccount(t *testing.T) {
+  	conf, d1 := preparetSend(t)
+  	d1.isConf = true
+  	conf.api = d1
+  	conf.accountNo = 0
+  	ListAndSelectAccount(conf)
+  	defer func() {
+  		globalAccountNo = -1
+  	}()
+  	if _, err := Walletnotify(conf); err != nil {
+  		t.Error(err)
+  	}
+  	balance := func() Amount {
+  		req := &Request{
+  			JSONRPC: "1.0",
+  			ID:      "curltest",
+  			Method:  "getbalance",
+  			Params:  []interface{}{"*", float64(0)},
+  		}
+  		var resp Response
+  		if err := getbalance(conf, req, &resp); err != nil {
+  			t.Fatal(err)
+  		}
+  		return resp.Result.(Amount)
+  	}
+  	bal0 := balance()
+  	testwalletpassphrase2(conf, d1)
+  	testsendtoaddress(conf, d1)
+  	if bal1 := balance(); bal1 >= bal0 {
+  		t.Error("getbalance must reflect the send", bal0, bal1)
+  	}
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		acs, err := listAccount(tx)
+  		if err != nil {
+  			return err
+  		}
+  		ac, err := getAccount(tx, "")
+  		if err != nil {
+  			return err
+  		}
+  		if !reflect.DeepEqual(ac.Balances, acs[0].Balances) {
+  			t.Error("the selected account must be read from the DB", ac.Balances, acs[0].Balances)
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  }
+
+  func TestSendSplit(t *testing.T) {
+  	conf, d1 := preparetSend(t)
+  	d1.isConf = true
+  	conf.api = d1
+  	if _, err := Walletnotify(conf); err != nil {
+  		t.Error(err)
+  	}
+  	testwalletpassphrase2(conf, d1)
+  	conf.MaxBundleSize = 5
+  	amounts := map[string]interface{}{}
+  	for _, c := range "ABCD" {
+  		adr := gadk.Address(string(c)) + gadk.EmptyAddress[1:]
+  		amounts[string(adr.WithChecksum())] = 0.01
+  	}
+  	var acc0 []Account
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		var err error
+  		acc0, err = listAccount(tx)
+  		return err
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+
+  	//the last bundle cannot be prepared.
+  	amounts[string(gadk.Address("E"+gadk.EmptyAddress[1:]).WithChecksum())] = 10000000.0
+  	req := &Request{
+  		Method: "sendmany",
+  		Params: []interface{}{"ac1", amounts},
+  	}
+  	var resp Response
+  	if err := sendmany(conf, req, &resp); err == nil {
+  		t.Error("should be error")
+  	}
+  	var acc1 []Account
+  	var outs []*outgoing
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		var err error
+  		if acc1, err = listAccount(tx); err != nil {
+  			return err
+  		}
+  		outs, err = listOutgoing(tx, true)
+  		return err
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  	if len(getDiff(acc0, acc1)) != 0 || len(outs) != 0 {
+  		t.Error("should be rollbacked", outs)
+  	}
+
+  	delete(amounts, string(gadk.Address("E"+gadk.EmptyAddress[1:]).WithChecksum()))
+  	resp = Response{}
+  	if err := sendmany(conf, req, &resp); err != nil {
+  		t.Fatal(err)
+  	}
+  	payout, ok := resp.Result.(gadk.Trytes)
+  	if !ok {
+  		t.Fatal("should return a hash", resp.Result)
+  	}
+  	if err := db.View(func(tx *bolt.Tx) error {
+  		outs, err := listOutgoing(tx, true)
+  		if err != nil {
+  			return err
+  		}
+  		if len(outs) < 2 || outs[0].Hash != payout {
+  			t.Error("should be split", outs)
+  		}
+  		var n int
+  		for _, o := range outs {
+  			if o.Payout != payout {
+  				t.Error("invalid payout", o.Payout)
+  			}
+  			bd, err := decodeRaw(string(o.Raw))
+  			if err != nil {
+  				return err
+  			}
+  			if len(bd) > conf.MaxBundleSize {
+  				t.Error("bundle is too large", len(bd))
+  			}
+  			if err := bd.IsValid(); err != nil {
+  				t.Error(err)
+  			}
+  			n += int(o.Amount)
+  		}
+  		if n != 4*1000000 {
+  			t.Error("invalid amount", n)
+  		}
+  		return nil
+  	}); err != nil {
+  		t.Fatal(err)
+  	}
+  }
+
   func testwalletpassphrase1(conf *Conf, d1 *dummy1) error {
   	req := &Request{
   		JSONRPC: "1.0",
@@ -365,4 +549,80 @@
   		adr1: int64(0.1 * 100000000),
   	})
   }
-  
\ No newline at end of file
+  
+  func TestWalletLock(t *testing.T) {
+  	conf := prepareTest(t)
+  	call := func(method string, params ...interface{}) error {
+  		req := &Request{
+  			JSONRPC: "1.0",
+  			ID:      "curltest",
+  			Method:  method,
+  			Params:  params,
+  		}
+  		var resp Response
+  		switch method {
+  		case "walletpassphrase":
+  			return walletpassphrase(conf, req, &resp)
+  		default:
+  			return walletlock(conf, req, &resp)
+  		}
+  	}
+  	if unlocker.isUnlocked() {
+  		t.Fatal("should be locked")
+  	}
+  	if err := call("walletpassphrase", "test", float64(-1)); err == nil {
+  		t.Error("negative timeout should be error")
+  	}
+  	if err := call("walletpassphrase", "test", 0.2); err != nil {
+  		t.Fatal(err)
+  	}
+  	if !unlocker.isUnlocked() || unlocker.unlockedUntil().IsZero() {
+  		t.Fatal("should be unlocked")
+  	}
+  	//extend the expiry; the first timer must not lock the wallet.
+  	if err := call("walletpassphrase", "test", float64(1)); err != nil {
+  		t.Fatal(err)
+  	}
+  	time.Sleep(400 * time.Millisecond)
+  	if !unlocker.isUnlocked() {
+  		t.Error("should be still unlocked")
+  	}
+  	if err := call("walletlock"); err != nil {
+  		t.Fatal(err)
+  	}
+  	if unlocker.isUnlocked() || !unlocker.unlockedUntil().IsZero() {
+  		t.Error("should be locked")
+  	}
+  	if err := sendtoaddress(conf, nil, nil); err != errNotPrivileged {
+  		t.Error("should not be privileged", err)
+  	}
+
+  	//shorten the expiry.
+  	if err := call("walletpassphrase", "test", float64(60)); err != nil {
+  		t.Fatal(err)
+  	}
+  	if err := call("walletpassphrase", "test", 0.1); err != nil {
+  		t.Fatal(err)
+  	}
+  	time.Sleep(300 * time.Millisecond)
+  	if unlocker.isUnlocked() {
+  		t.Error("should be locked after the timeout")
+  	}
+
+  	//a huge timeout is clamped instead of overflowing.
+  	if err := call("walletpassphrase", "test", 1e300); err != nil {
+  		t.Fatal(err)
+  	}
+  	if until := unlocker.unlockedUntil(); until.Before(time.Now().Add(maxUnlockTime - time.Minute)) {
+  		t.Error("timeout should be clamped to the max", until)
+  	}
+  	if err := call("walletlock"); err != nil {
+  		t.Fatal(err)
+  	}
+
+  	conf.PassPhrase = false
+  	err := call("walletpassphrase", "test", float64(60))
+  	if e, ok := err.(*Err); !ok || e.Code != RPCWalletWrongEncState {
+  		t.Error("walletpassphrase should fail with an unencrypted wallet", err)
+  	}
+  }
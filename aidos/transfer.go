@@ -24,10 +24,10 @@
   	"errors"
   	"fmt"
   	"log"
-  	"sync"
   	"time"
 
   	"github.com/AidosKuneen/gadk"
+  	"github.com/boltdb-go/bolt"
   )
 
   /*
@@ -57,10 +57,32 @@
 
   //PrepareTransfers gets an array of transfer objects as input,
   //and then prepare the transfer by generating the correct bundle,
-  // as well as choosing and signing the inputs if necessary (if it's a value transfer).
-  func PrepareTransfers(api apis, ac *Account, trs []gadk.Transfer) (gadk.Bundle, error) {
-  	var err error
+  // as well as choosing the inputs by the coin selection policy and signing them if necessary (if it's a value transfer).
+  func PrepareTransfers(api apis, ac *Account, trs []gadk.Transfer, policy string) (gadk.Bundle, error) {
+  	bundle, err := fundTransfers(api, ac, trs, "", policy)
+  	if err != nil {
+  		return nil, err
+  	}
+  	if err := signBundle(ac, bundle); err != nil {
+  		return nil, err
+  	}
+  	return bundle, nil
+  }
 
+  //signBundle signs inputs of the finalized bundle with the seed of ac if it has any.
+  func signBundle(ac *Account, bundle gadk.Bundle) error {
+  	if !hasInputs(bundle) {
+  		return nil
+  	}
+  	return ac.withSeed(func(seed gadk.Trytes) error {
+  		return signInputs(ac, seed, bundle)
+  	})
+  }
+
+  //fundTransfers makes a finalized but unsigned bundle which sends trs from ac.
+  //Inputs are chosen by policy, and change is sent to changeAdr, or a new address derived from the seed of ac
+  //if changeAdr is empty.
+  func fundTransfers(api apis, ac *Account, trs []gadk.Transfer, changeAdr gadk.Address, policy string) (gadk.Bundle, error) {
   	bundle, frags, total := addOutputs(trs)
   	// Get inputs if we are sending tokens
   	if total <= 0 {
@@ -70,65 +92,87 @@
   	}
 
   	if total > ac.totalValueWithChange() {
-  		return nil, errors.New("Not enough balance")
+  		return nil, errInsufficientBalance
+  	}
+  	//ac is read and stored in one DB transaction while mutex is locked, so a change address
+  	//is never given to concurrent sends. The address is appended to Balances and signInputs
+  	//regards its position as the key index, so it must be derived from index len(ac.Balances).
+  	change := func() (gadk.Address, error) {
+  		if changeAdr != "" {
+  			return changeAdr, nil
+  		}
+  		var adr gadk.Address
+  		err := ac.withSeed(func(seed gadk.Trytes) error {
+  			var err error
+  			if adr, err = gadk.NewAddress(seed, len(ac.Balances), 2); err != nil {
+  				return err
+  			}
+  			if ac.search(adr) >= 0 {
+  				return errChangeCollision
+  			}
+  			return nil
+  		})
+  		return adr, err
   	}
-  	sufficient, err := addRemainder(api, &bundle, ac, total, false)
+  	sufficient, err := addRemainder(api, &bundle, ac, change, total, policy)
   	if err != nil {
   		return nil, err
   	}
   	if !sufficient {
-  		return nil, errors.New("insufficient balance")
+  		return nil, errInsufficientBalance
   	}
   	bundle.Finalize(frags)
-  	err = signInputs(ac, bundle)
-  	return bundle, err
+  	return bundle, nil
   }
 
-  func addRemainder(api apis, bundle *gadk.Bundle, ac *Account, total int64, useChange bool) (bool, error) {
-  	for i, bal := range ac.Balances {
-  		value := bal.Value
-  		if useChange {
-  			value = bal.Change
-  		}
-  		if value <= 0 {
-  			continue
+  func hasInputs(bundle gadk.Bundle) bool {
+  	for _, tx := range bundle {
+  		if tx.Value < 0 {
+  			return true
   		}
+  	}
+  	return false
+  }
+
+  //addRemainder adds inputs chosen by policy and a change output to bundle, and deducts balances of them from ac.
+  func addRemainder(api apis, bundle *gadk.Bundle, ac *Account, change func() (gadk.Address, error), total int64, policy string) (bool, error) {
+  	idx, err := selectCoins(policy, ac.Balances, total)
+  	if err != nil || idx == nil {
+  		return false, err //balance is not sufficient
+  	}
+  	var sum int64
+  	for _, i := range idx {
+  		value := ac.Balances[i].Value
   		// Add input as bundle entry
-  		bundle.Add(2, bal.Address, -value, time.Now(), gadk.EmptyHash)
+  		bundle.Add(2, ac.Balances[i].Address, -value, time.Now(), gadk.EmptyHash)
   		ac.Balances[i].Value -= value
-  		if useChange {
-  			ac.Balances[i].Change = 0
+  		ac.Balances[i].Spent += value
+  		sum += value
+  	}
+  	// If there is a remainder value
+  	// Add extra output to send remaining funds to
+  	if remain := sum - total; remain > 0 {
+  		adr, err := change()
+  		if err != nil {
+  			return false, err
   		}
-  		// If there is a remainder value
-  		// Add extra output to send remaining funds to
-  		if remain := value - total; remain > 0 {
-  			// If user has provided remainder address
-  			// Use it to send remaining funds to
-  			// Generate a new Address by calling getNewAddress
-  			adr, err := gadk.NewAddress(ac.Seed, len(ac.Balances), 2)
-  			if err != nil {
-  				return false, err
-  			}
+  		if j := ac.search(adr); j >= 0 {
+  			ac.Balances[j].Change += remain
+  		} else {
   			ac.Balances = append(ac.Balances, Balance{
   				Balance: gadk.Balance{
   					Address: adr,
   				},
   				Change: remain,
   			})
-  			// Remainder bundle entry
-  			bundle.Add(1, adr, remain, time.Now(), gadk.EmptyHash)
-  			return true, nil
-  		}
-  		// If multiple inputs provided, subtract the totalTransferValue by
-  		// the inputs balance
-  		if total -= value; total == 0 {
-  			return true, nil
   		}
+  		// Remainder bundle entry
+  		bundle.Add(1, adr, remain, time.Now(), gadk.EmptyHash)
   	}
-  	return false, nil //balance is not sufficient
+  	return true, nil
   }
 
-  func signInputs(ac *Account, bundle gadk.Bundle) error {
+  func signInputs(ac *Account, seed gadk.Trytes, bundle gadk.Bundle) error {
   	//  Get the normalized bundle hash
   	nHash := bundle.Hash().Normalize()
 
@@ -141,6 +185,10 @@
   		if bd.Value >= 0 {
   			continue
   		}
+  		//an input must be followed by a tx for the 2nd signature fragment, which may be missing in a raw bundle.
+  		if i+1 >= len(bundle) || bundle[i+1].Address != bd.Address || bundle[i+1].Value != 0 {
+  			return errMalformedInput
+  		}
   		// Get the corresponding keyIndex and security of the address
   		index := -1
   		for i, b := range ac.Balances {
@@ -153,7 +201,7 @@
   			return errors.New("cannot find address")
   		}
   		// Get corresponding private key of address
-  		key := gadk.NewKey(ac.Seed, index, 2)
+  		key := gadk.NewKey(seed, index, 2)
   		//  Calculate the new signatureFragment with the first bundle fragment
   		bundle[i].SignatureMessageFragment = gadk.Sign(nHash[:27], key[:6561/3])
 
@@ -162,17 +210,15 @@
   		//  Because the signature is > 2187 trytes, we need to
   		//  find the subsequent transaction to add the remainder of the signature
   		//  Same address as well as value = 0 (as we already spent the input)
-  		if bundle[i+1].Address == bd.Address && bundle[i+1].Value == 0 {
-  			//  Calculate the new signature
-  			nfrag := gadk.Sign(nHash[27:27*2], key[6561/3:2*6561/3])
-  			//  Convert signature to trytes and assign it again to this bundle entry
-  			bundle[i+1].SignatureMessageFragment = nfrag
-  		}
+  		//  Calculate the new signature
+  		nfrag := gadk.Sign(nHash[27:27*2], key[6561/3:2*6561/3])
+  		//  Convert signature to trytes and assign it again to this bundle entry
+  		bundle[i+1].SignatureMessageFragment = nfrag
   	}
   	return nil
   }
 
-  func doPow(tra *gadk.GetTransactionsToApproveResponse, depth int64, trytes []gadk.Transaction, mwm int64, pow gadk.PowFunc) error {
+  func doPow(tra *gadk.GetTransactionsToApproveResponse, trytes []gadk.Transaction, mwm int64, pow gadk.PowFunc) error {
   	var prev gadk.Trytes
   	var err error
   	for i := len(trytes) - 1; i >= 0; i-- {
@@ -193,13 +239,13 @@
   	return nil
   }
 
-  //PowTrytes does attachToMesh.
-  func PowTrytes(api apis, depth int64, trytes []gadk.Transaction, mwm int64, pow gadk.PowFunc) error {
+  //PowTrytes does attachToMesh by pow.
+  func PowTrytes(api apis, depth int64, trytes []gadk.Transaction, mwm int64, pow PowProvider) error {
   	tra, err := api.GetTransactionsToApprove(depth)
   	if err != nil {
   		return err
   	}
-  	if err := doPow(tra, depth, trytes, mwm, pow); err != nil {
+  	if err := pow.Attach(tra, trytes, mwm); err != nil {
   		return err
   	}
   	if err := gadk.Bundle(trytes).IsValid(); err != nil {
@@ -232,62 +278,93 @@
   	return true
   }
 
-  var pow gadk.PowFunc
-  var PowInfo string
-  func init() {
-  	PowInfo, pow = gadk.GetBestPoW()
-  }
-
-  var powMutex = sync.Mutex{}
-
-  //Send sends token.
-  //if you need to pow locally, you must specifiy pow func.
-  //otherwirse this calls AttachToMesh API.
-  func Send(conf *Conf, ac *Account, mwm int64, trs []gadk.Transfer) (gadk.Trytes, error) {
+  //Send prepares bundles which send trs from ac, and queues them to the outbox in tx.
+  //trs are split into several bundles if a bundle would have more than conf.MaxBundleSize txs,
+  //and all of them are marked with the hash of the first one as the payout.
+  //Inputs are chosen by the coin selection policy, or the one in aidosd.conf if policy is empty.
+  //PoW and broadcasting are done by processOutbox after tx is committed.
+  //If any of bundles cannot be prepared, balances of ac are restored and nothing is queued
+  //(i.e. the caller must rollback tx).
+  func Send(tx *bolt.Tx, conf *Conf, ac *Account, trs []gadk.Transfer, policy string) ([]gadk.Trytes, error) {
+  	policy, err := conf.coinSelection(policy)
+  	if err != nil {
+  		return nil, err
+  	}
   	bals := make([]Balance, len(ac.Balances))
   	copy(bals, ac.Balances)
-  	bd, err := PrepareTransfers(conf.api, ac, trs)
-  	if err != nil {
-  		ac.Balances = bals
-  		return "", err
+  	var hashes []gadk.Trytes
+  	for len(trs) > 0 {
+  		bd, n, err := prepareBundle(conf.api, ac, trs, policy, conf.MaxBundleSize)
+  		if err == nil {
+  			err = queueBundle(tx, ac, bd, trs[:n], outPrepared)
+  		}
+  		if err != nil {
+  			ac.Balances = bals
+  			return nil, err
+  		}
+  		hashes = append(hashes, bd.Hash())
+  		trs = trs[n:]
   	}
-  	hash := bd.Hash()
-  	go func() {
-  		powMutex.Lock()
-  		defer powMutex.Unlock()
-  		log.Println("starting PoW... (",PowInfo,")")
-  		ts := []gadk.Transaction(bd)
-  		for i := 0; ; i++ {
-  			err = PowTrytes(conf.api, gadk.Depth, ts, mwm, pow)
-  			if err == nil {
-  				break
-  			}
-  			log.Println(err, " waiting 3 minuites ", i)
-  			log.Println("failed to pow ", bd)
-  			time.Sleep(3 * time.Minute)
+  	if len(hashes) > 1 {
+  		if err := setPayout(tx, hashes); err != nil {
+  			ac.Balances = bals
+  			return nil, err
   		}
-  		for i := 0; ; i++ {
-  			var bd2 gadk.Bundle
-  			if errr := broadcast(conf.api, ts); errr == nil {
-  				bd2 = gadk.Bundle(ts)
-  				log.Println("finish sending. bundle hash=", bd2.Hash())
-  				break
+  	}
+  	tx.OnCommit(func() {
+  		go processOutbox(conf)
+  	})
+  	return hashes, nil
+  }
+
+  //prepareBundle prepares a bundle which sends the first n transfers of trs from ac,
+  //where n is the max number of transfers such that the bundle has at most maxSize txs.
+  //All of trs are sent if maxSize is 0.
+  //The size is fixed with unsigned bundles, and only the final one is signed.
+  func prepareBundle(api apis, ac *Account, trs []gadk.Transfer, policy string, maxSize int) (gadk.Bundle, int, error) {
+  	n := len(trs)
+  	//at least an input (2 txs) and a change are needed.
+  	if maxSize > 0 && n > maxSize-3 {
+  		n = maxSize - 3
+  	}
+  	if n < 1 {
+  		n = 1
+  	}
+  	for {
+  		bals := make([]Balance, len(ac.Balances))
+  		copy(bals, ac.Balances)
+  		bd, err := fundTransfers(api, ac, trs[:n], "", policy)
+  		if err != nil {
+  			return nil, 0, err
+  		}
+  		if maxSize <= 0 || len(bd) <= maxSize {
+  			if err := signBundle(ac, bd); err != nil {
+  				return nil, 0, err
   			}
-        // no rebroadcast required
-  			// if _, ok := conf.api.(*gadk.API); ok && !conf.Testnet {
-  			// 	// TODO Remove hardcoded URLs
-  			// 	for _, w := range []string{"http://wallet1.aidoskuneen.com:14266", "http://wallet2.aidoskuneen.com:14266"} {
-  			// 		api2 := gadk.NewAPI(w, nil)
-  			// 		if err := api2.BroadcastTransactions(ts); err != nil {
-  			// 			log.Println(err)
-  			// 		}
-  			// 	}
-  			// }
-  			log.Println("failed to send ", bd2)
-  			log.Println(err, " waiting 3 minuites ", i)
-  			time.Sleep(3 * time.Minute)
+  			return bd, n, nil
+  		}
+  		ac.Balances = bals
+  		if n == 1 {
+  			return nil, 0, errBundleTooLarge
+  		}
+  		if n -= len(bd) - maxSize; n < 1 {
+  			n = 1
   		}
-  		log.Println("finished PoW...")
-  	}()
-  	return hash, nil
+  	}
+  }
+
+  //queueBundle puts bd which sends trs from ac to the outbox with state.
+  func queueBundle(tx *bolt.Tx, ac *Account, bd gadk.Bundle, trs []gadk.Transfer, state string) error {
+  	var amount int64
+  	for _, tr := range trs {
+  		amount += tr.Value
+  	}
+  	return putOutgoing(tx, &outgoing{
+  		Hash:    bd.Hash(),
+  		Account: ac.Name,
+  		Amount:  amount,
+  		Raw:     encodeRaw(bd),
+  		State:   state,
+  		Created: time.Now(),
+  	})
   }
@@ -0,0 +1,91 @@
+  // Copyright (c) 2017 Aidos Developer
+  
+  // Permission is hereby granted, free of charge, to any person obtaining a copy
+  // of this software and associated documentation files (the "Software"), to deal
+  // in the Software without restriction, including without limitation the rights
+  // to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+  // copies of the Software, and to permit persons to whom the Software is
+  // furnished to do so, subject to the following conditions:
+  
+  // The above copyright notice and this permission notice shall be included in
+  // all copies or substantial portions of the Software.
+  
+  // THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+  // IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+  // FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+  // AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+  // LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+  // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+  // THE SOFTWARE.
+  
+  package aidos
+  import (
+  	"sync"
+  	"time"
+  )
+
+  //maxUnlockTime is the max timeout of walletpassphrase, same as bitcoind.
+  const maxUnlockTime = 100000000 * time.Second
+
+  //unlockState is the state of the wallet unlocked by walletpassphrase.
+  type unlockState struct {
+  	sync.RWMutex
+  	always bool      //true if passphrase=false in aidosd.conf
+  	until  time.Time //zero if locked
+  	timer  *time.Timer
+  }
+
+  var unlocker unlockState
+
+  //isUnlocked returns true if sending tokens is allowed now.
+  func (u *unlockState) isUnlocked() bool {
+  	u.RLock()
+  	defer u.RUnlock()
+  	return u.always || time.Now().Before(u.until)
+  }
+
+  //unlockedUntil returns the time when the wallet will be locked, or zero if locked.
+  func (u *unlockState) unlockedUntil() time.Time {
+  	u.RLock()
+  	defer u.RUnlock()
+  	if !time.Now().Before(u.until) {
+  		return time.Time{}
+  	}
+  	return u.until
+  }
+
+  //unlock unlocks the wallet for d. If the wallet is already unlocked,
+  //the expiry is replaced with the new one.
+  func (u *unlockState) unlock(d time.Duration) {
+  	if d > maxUnlockTime {
+  		d = maxUnlockTime
+  	}
+  	u.Lock()
+  	defer u.Unlock()
+  	if u.timer != nil {
+  		u.timer.Stop()
+  	}
+  	until := time.Now().Add(d)
+  	u.until = until
+  	u.timer = time.AfterFunc(d, func() {
+  		u.Lock()
+  		//the expiry may have been changed after the timer was stopped.
+  		expired := u.until.Equal(until)
+  		if expired {
+  			u.until = time.Time{}
+  			u.timer = nil
+  		}
+  		u.Unlock()
+  	})
+  }
+
+  //lock locks the wallet immediately.
+  func (u *unlockState) lock() {
+  	u.Lock()
+  	if u.timer != nil {
+  		u.timer.Stop()
+  		u.timer = nil
+  	}
+  	u.until = time.Time{}
+  	u.Unlock()
+  }
@@ -9,8 +9,8 @@
   	github.com/gorilla/rpc v1.2.0
   	github.com/mattn/go-shellwords v1.0.12
   	github.com/natefinch/lumberjack v2.0.0+incompatible
-  	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c // indirect
-  	golang.org/x/term v0.0.0-20210220032956-6a3ed077a48d
+  	golang.org/x/crypto v0.14.0
+  	golang.org/x/term v0.14.0
   	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
   	gopkg.in/yaml.v2 v2.4.0 // indirect
  )
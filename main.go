@@ -55,13 +55,17 @@
   		fmt.Fprintf(os.Stderr, "%s <options>\n", os.Args[0])
   		flag.PrintDefaults()
   	}
-  	var child, start, status, stop, refresh, showSeed, initialize bool
+  	var child, start, status, stop, refresh, reindex, migrateDryRun, showSeed, exportAddresses, changePassword, initialize bool
   	flag.BoolVar(&child, "child", false, "start as child")
   	flag.BoolVar(&start, "start", false, "start aidosd (default behaviour)")
   	flag.BoolVar(&status, "status", false, "show status")
   	flag.BoolVar(&stop, "stop", false, "stop aidosd")
   	flag.BoolVar(&refresh, "refresh", false, "refresh the DB (danger!)")
+  	flag.BoolVar(&reindex, "reindex", false, "rebuild indexes of transactions in the DB")
+  	flag.BoolVar(&migrateDryRun, "migrate-dry-run", false, "show migrations of the DB schema without applying them")
   	flag.BoolVar(&showSeed, "show_seed", false, "show the seed")
+  	flag.BoolVar(&exportAddresses, "export_addresses", false, "print all addresses for importing to a watch-only wallet")
+  	flag.BoolVar(&changePassword, "change_password", false, "change the password to encrypt seeds")
 		flag.BoolVar(&initialize, "initialize", false, "set up a new account (warning! clears any existing account!)")
   	flag.Parse()
 
@@ -136,6 +140,32 @@
   		aidos.ResetDB(conf)
   		fmt.Println("refreshed")
   	}
+  	if reindex {
+  		aidos.SetLog(true)
+  		log.Println("Please ensure that aidosd is stopped in advance")
+  		pwd := getPasswd()
+  		if _, err := aidos.Prepare("aidosd.conf", pwd); err != nil {
+  			log.Fatal(err)
+  		}
+  		if err := aidos.RebuildIndex(); err != nil {
+  			log.Fatal(err)
+  		}
+  		fmt.Println("reindexed")
+  	}
+  	if migrateDryRun {
+  		aidos.SetLog(true)
+  		log.Println("Please ensure that aidosd is stopped in advance")
+  		done, err := aidos.MigrateDryRun()
+  		if err != nil {
+  			log.Fatal(err)
+  		}
+  		if len(done) == 0 {
+  			fmt.Println("the DB schema is up to date")
+  		}
+  		for _, d := range done {
+  			fmt.Println("will migrate", d)
+  		}
+  	}
   	if showSeed {
   		aidos.SetLog(true)
   		log.Println("Please ensure that aidosd is stopped in advance")
@@ -148,6 +178,31 @@
   			log.Fatal(err)
   		}
   	}
+  	if exportAddresses {
+  		pwd := getPasswd()
+  		if _, err := aidos.Prepare("aidosd.conf", pwd); err != nil {
+  			log.Fatal(err)
+  		}
+  		if err := aidos.ExportAddresses(os.Stdout); err != nil {
+  			log.Fatal(err)
+  		}
+  	}
+  	if changePassword {
+  		aidos.SetLog(true)
+  		log.Println("Please ensure that aidosd is stopped in advance")
+  		pwd := getPasswd()
+  		if _, err := aidos.Prepare("aidosd.conf", pwd); err != nil {
+  			log.Fatal(err)
+  		}
+  		npwd := readPasswd("Enter new password: ")
+  		if !bytes.Equal(npwd, readPasswd("Retype new password: ")) {
+  			log.Fatal("passwords do not match")
+  		}
+  		if err := aidos.ChangePassword(pwd, npwd); err != nil {
+  			log.Fatal(err)
+  		}
+  		fmt.Println("the password has been changed")
+  	}
   }
 
   func callStatus() (byte, error) {
@@ -171,6 +226,7 @@
   	if err != nil {
   		return err
   	}
+  	conf.Version = Version
 
   	// check for multiple accounts:
     aidos.ListAndSelectAccount(conf);
@@ -197,6 +253,11 @@
   	if err := aidos.UpdateTXs(conf); err != nil {
   		log.Fatal(err)
   	}
+  	if err := aidos.Reconcile(conf); err != nil {
+  		log.Println("failed to reconcile balances:", err)
+  	}
+  	go aidos.RunOutbox(conf)
+  	go aidos.RunReconcile(conf)
   	fmt.Println("starting the aidosd server at port http://0.0.0.0:" + conf.RPCPort)
   	mux := http.NewServeMux()
   	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -264,9 +325,14 @@
   }
 
   func getPasswd() []byte {
-  	fmt.Print("Enter password: ")
+  	return readPasswd("Enter password: ")
+  }
+
+  //readPasswd prints prompt to stderr, so that outputs like -export_addresses can be redirected.
+  func readPasswd(prompt string) []byte {
+  	fmt.Fprint(os.Stderr, prompt)
   	pwd, err := term.ReadPassword(int(syscall.Stdin)) //int conversion is needed for win
-  	fmt.Println("")
+  	fmt.Fprintln(os.Stderr, "")
   	if err != nil {
   		panic(err)
   	}